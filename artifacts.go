@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	defaultMaxArtifactSize = 1 << 30
+
+	latestVersion = "latest" // Stands for the version of the latest successful build
+)
+
+var (
+	errArtifactTooLarge = errors.New("Artifact too large")
+	errPartialPlatform  = errors.New("os, arch and kind must be given together")
+
+	regOS   = regexp.MustCompile(`^[a-zA-Z0-9_]{1,32}$`)
+	regArch = regexp.MustCompile(`^[a-zA-Z0-9_]{1,10}$`)
+)
+
+// sizeLimitReader fails once more than max bytes have been read, so that an
+// upload which is too large is abandoned rather than truncated
+type sizeLimitReader struct {
+	r    io.Reader
+	max  int64
+	read int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, errArtifactTooLarge
+	}
+	return n, err
+}
+
+// artifactKey returns the key of the binary of a build in the blob store
+func artifactKey(name, version string) string {
+	return name + "/" + version
+}
+
+// platformArtifactKey returns the key of an artifact for a platform in the
+// blob store
+func platformArtifactKey(name, version string, a *models.Artifact) string {
+	return artifactKey(name, version) + "." + strings.Replace(a.Key(), "/", "-", -1)
+}
+
+// platformFromQuery returns the OS, architecture and kind of an artifact from
+// the os, arch and kind parameters, or nil if none were given
+func platformFromQuery(q url.Values) (*models.Artifact, error) {
+	a := &models.Artifact{OS: q.Get("os"), Architecture: q.Get("arch"), Kind: q.Get("kind")}
+	switch {
+	case a.OS == "" && a.Architecture == "" && a.Kind == "":
+		return nil, nil
+	case a.OS == "" || a.Architecture == "" || a.Kind == "":
+		return nil, errPartialPlatform
+	case !regOS.MatchString(a.OS):
+		return nil, fmt.Errorf("Invalid os %q", a.OS)
+	case !regArch.MatchString(a.Architecture):
+		return nil, fmt.Errorf("Invalid arch %q", a.Architecture)
+	}
+	switch a.Kind {
+	case models.ArtifactBinary, models.ArtifactJar, models.ArtifactDocker, models.ArtifactTarball:
+		return a, nil
+	}
+	return nil, fmt.Errorf("Invalid kind %q", a.Kind)
+}
+
+// parseArtifactBaseURL checks the base URL of the service given with
+// -artifact-url. It's required rather than taken from the Host of uploads, as
+// clients could then save links to any host.
+func parseArtifactBaseURL(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("-artifact-url is required with -artifact-dir")
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("-artifact-url must be an http or https URL, not %q", s)
+	}
+	return strings.TrimRight(s, "/"), nil
+}
+
+// artifactURL returns the URL the binary of a build, or its artifact for a
+// platform, is downloaded from, relative to artifactBaseURL
+func artifactURL(name, version string, platform *models.Artifact) string {
+	u := fmt.Sprintf("%s/builds/%s/%s/artifact", artifactBaseURL, url.PathEscape(name), url.PathEscape(version))
+	if platform != nil {
+		u += "?" + url.Values{"os": {platform.OS}, "arch": {platform.Architecture}, "kind": {platform.Kind}}.Encode()
+	}
+	return u
+}
+
+// deleteArtifacts removes the stored binary and artifacts of a build which no
+// longer exists
+func deleteArtifacts(b *models.Build) {
+	if artifactStore == nil {
+		return
+	}
+	keys := []string{artifactKey(b.Name, b.Version)}
+	for i := range b.Artifacts {
+		keys = append(keys, platformArtifactKey(b.Name, b.Version, &b.Artifacts[i]))
+	}
+	for _, key := range keys {
+		if err := artifactStore.Delete(key); err != nil {
+			log.Printf("Failed to delete artifact %s: %v", key, err)
+		}
+	}
+}
+
+func putArtifactHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("PUT ARTIFACT", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	if serviceName == "" {
+		logHTTPError(rw, "Missing Service Name", http.StatusBadRequest)
+		return
+	}
+
+	if buildVersion == "" {
+		logHTTPError(rw, "Missing version", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(rw, r, actionWrite, serviceName) {
+		return
+	}
+
+	if artifactStore == nil {
+		logHTTPError(rw, "Artifact storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	platform, err := platformFromQuery(r.URL.Query())
+	if err != nil {
+		logHTTPError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if platform != nil && platform.Kind == models.ArtifactDocker {
+		logHTTPError(rw, "Docker images are kept in a registry", http.StatusBadRequest)
+		return
+	}
+
+	if r.Body == nil {
+		logHTTPError(rw, "No PUT body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	if r.ContentLength > maxArtifactSize {
+		logHTTPError(rw, fmt.Sprintf("The artifact must be at most %d bytes", maxArtifactSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	key := artifactKey(serviceName, buildVersion)
+	if platform != nil {
+		key = platformArtifactKey(serviceName, buildVersion, platform)
+	}
+
+	hash := sha256.New()
+	body := &sizeLimitReader{r: io.TeeReader(r.Body, hash), max: maxArtifactSize}
+	size, err := artifactStore.Put(key, body)
+	if err == errArtifactTooLarge {
+		logHTTPError(rw, fmt.Sprintf("The artifact must be at most %d bytes", maxArtifactSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error storing artifact: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	binaryURL := artifactURL(serviceName, buildVersion, platform)
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	audit := newAuditEvent(r, models.AuditArtifact, serviceName, buildVersion)
+	if platform != nil {
+		platform.URL, platform.SHA256, platform.Size = binaryURL, checksum, size
+		if err := buildRepo.SetArtifact(serviceName, buildVersion, platform, audit); err != nil {
+			logHTTPError(rw, fmt.Sprintf("Error saving artifact: %v", err), http.StatusInternalServerError)
+			return
+		}
+		build.SetArtifact(*platform)
+	} else {
+		if err := buildRepo.SetBinary(serviceName, buildVersion, binaryURL, checksum, size, audit); err != nil {
+			logHTTPError(rw, fmt.Sprintf("Error saving binary URL: %v", err), http.StatusInternalServerError)
+			return
+		}
+		build.BinaryURL, build.BinarySHA256, build.BinarySize = binaryURL, checksum, size
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(build)
+}
+
+// getArtifactHandler downloads the binary of a build, or its artifact for a
+// platform, supporting range and conditional requests
+func getArtifactHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET ARTIFACT", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	if artifactStore == nil {
+		logHTTPError(rw, "Artifact storage is disabled", http.StatusNotImplemented)
+		return
+	}
+
+	platform, err := platformFromQuery(r.URL.Query())
+	if err != nil {
+		logHTTPError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	key, filename, checksum := artifactKey(serviceName, buildVersion), serviceName+"-"+buildVersion, build.BinarySHA256
+	if platform != nil {
+		key = platformArtifactKey(serviceName, buildVersion, platform)
+		filename += "-" + strings.ToLower(platform.OS+"-"+platform.Architecture)
+		checksum = ""
+		for _, a := range build.Artifacts {
+			if a.Key() == platform.Key() {
+				checksum = a.SHA256
+			}
+		}
+	}
+
+	blob, modTime, err := artifactStore.Open(key)
+	if err == errBlobNotFound {
+		logHTTPError(rw, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error opening artifact: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if checksum != "" {
+		rw.Header().Set(headerChecksum, checksum)
+	}
+	http.ServeContent(rw, r, filename, modTime, blob)
+}
+
+// getArtifactsHandler finds the artifacts of a build, or of the latest
+// successful build on a branch, for an OS, architecture and kind
+func getArtifactsHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET ARTIFACTS", r.URL)
+
+	query := r.URL.Query()
+	serviceName := query.Get(":name")
+	buildVersion := query.Get(":version")
+
+	var build *models.Build
+	var err error
+	if buildVersion == latestVersion {
+		build, err = buildRepo.GetLatest(serviceName, query.Get("branch"))
+	} else {
+		build, err = buildRepo.GetVersion(serviceName, buildVersion)
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	artifacts := build.FindArtifacts(query.Get("os"), query.Get("arch"), query.Get("kind"))
+	if len(artifacts) == 0 {
+		logHTTPError(rw, "No matching artifacts", http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(&models.BuildArtifacts{
+		Name:      build.Name,
+		Version:   build.Version,
+		Branch:    build.Branch,
+		Artifacts: artifacts,
+	})
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestFileBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := newFileBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := store.Put("com.example/1", strings.NewReader("binary")); err != nil || n != 6 {
+		t.Fatalf("Expected 6 bytes to be stored, Got %d: %v", n, err)
+	}
+	blob, _, err := store.Open("com.example/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(blob)
+	blob.Close()
+	if string(data) != "binary" {
+		t.Errorf("Expected binary, Got %q", data)
+	}
+
+	if err := store.Delete("com.example/1"); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := store.Open("com.example/1"); err != errBlobNotFound {
+		t.Errorf("Expected %v, Got %v", errBlobNotFound, err)
+	}
+
+	for _, key := range []string{"../escaped", "com.example/../../escaped", ""} {
+		if _, err := store.Put(key, strings.NewReader("binary")); err == nil {
+			t.Errorf("Expected key %q to be refused", key)
+		}
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func() { artifactStore, artifactBaseURL, maxArtifactSize = nil, "", defaultMaxArtifactSize }()
+	artifactStore, _ = newFileBlobStore(dir)
+	artifactBaseURL, _ = parseArtifactBaseURL("https://builds.example.com/")
+	maxArtifactSize = 16
+
+	repo := newTestRepo()
+	buildRepo = repo
+	build := validBuild()
+	repo.builds = append(repo.builds, &build)
+
+	path := "/builds/com.HailoOSS.kernel.build-service/20130627091746/artifact"
+	testCases := []struct {
+		method         string
+		path           string
+		body           string
+		rangeHeader    string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"GET", path, "", "", http.StatusNotFound, ""},
+		{"PUT", "/builds/com.HailoOSS.kernel.build-service/20130627091747/artifact", "binary", "", http.StatusNotFound, ""},
+		{"PUT", path, "a binary which is too large", "", http.StatusRequestEntityTooLarge, ""},
+		{"PUT", path, "0123456789", "", http.StatusOK, ""},
+		{"GET", path, "", "", http.StatusOK, "0123456789"},
+		{"GET", path, "", "bytes=2-5", http.StatusPartialContent, "2345"},
+		{"GET", path, "", "bytes=20-", http.StatusRequestedRangeNotSatisfiable, ""},
+		{"PUT", path + "?os=linux", "arm64", "", http.StatusBadRequest, ""},
+		{"PUT", path + "?os=linux&arch=arm64&kind=docker", "arm64", "", http.StatusBadRequest, ""},
+		{"PUT", path + "?os=linux&arch=arm64&kind=binary", "arm64", "", http.StatusOK, ""},
+		{"GET", path + "?os=linux&arch=arm64&kind=binary", "", "", http.StatusOK, "arm64"},
+		{"GET", path + "?os=darwin&arch=arm64&kind=binary", "", "", http.StatusNotFound, ""},
+		{"GET", path, "", "", http.StatusOK, "0123456789"},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, "http://attacker.example.com"+tc.path, strings.NewReader(tc.body))
+		if tc.rangeHeader != "" {
+			req.Header.Set("Range", tc.rangeHeader)
+		}
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+		if tc.expectedBody != "" && recorder.Body.String() != tc.expectedBody {
+			t.Errorf("Expected %q, Got %q (%d)", tc.expectedBody, recorder.Body, i)
+		}
+		if tc.method == "PUT" && tc.expectedStatus == http.StatusOK && !strings.Contains(tc.path, "?") {
+			updated := new(models.Build)
+			json.NewDecoder(recorder.Body).Decode(updated)
+			if updated.BinaryURL != "https://builds.example.com"+path || repo.builds[0].BinaryURL != updated.BinaryURL {
+				t.Errorf("Expected the BinaryURL to be the artifact, Got %q (%d)", updated.BinaryURL, i)
+			}
+			if sum := sha256.Sum256([]byte(tc.body)); updated.BinarySHA256 != hex.EncodeToString(sum[:]) || updated.BinarySize != int64(len(tc.body)) {
+				t.Errorf("Expected the checksum of the artifact, Got %q %d (%d)", updated.BinarySHA256, updated.BinarySize, i)
+			}
+		}
+	}
+
+	if len(repo.audit) != 2 || repo.audit[0].Action != models.AuditArtifact || repo.audit[0].Before.BinaryURL != "http://s3.amazon.com/abcdefg" {
+		t.Errorf("Expected the uploads to be audited, Got %+v", repo.audit)
+	}
+
+	sum := sha256.Sum256([]byte("arm64"))
+	expected := models.Artifact{
+		OS:           "linux",
+		Architecture: "arm64",
+		Kind:         models.ArtifactBinary,
+		URL:          "https://builds.example.com" + path + "?arch=arm64&kind=binary&os=linux",
+		SHA256:       hex.EncodeToString(sum[:]),
+		Size:         5,
+	}
+	if len(build.Artifacts) != 1 || build.Artifacts[0] != expected {
+		t.Errorf("Expected %+v, Got %+v", expected, build.Artifacts)
+	}
+
+	deleteArtifacts(&build)
+	for _, key := range []string{artifactKey(build.Name, build.Version), platformArtifactKey(build.Name, build.Version, &expected)} {
+		if _, _, err := artifactStore.Open(key); err != errBlobNotFound {
+			t.Errorf("Expected %s to be deleted, Got %v", key, err)
+		}
+	}
+}
+
+func TestParseArtifactBaseURL(t *testing.T) {
+	testCases := []struct {
+		url         string
+		expected    string
+		expectError bool
+	}{
+		{"https://builds.example.com/", "https://builds.example.com", false},
+		{"http://builds.example.com:3000", "http://builds.example.com:3000", false},
+		{"", "", true},
+		{"builds.example.com", "", true},
+		{"ftp://builds.example.com", "", true},
+	}
+
+	for i, tc := range testCases {
+		base, err := parseArtifactBaseURL(tc.url)
+		if (err != nil) != tc.expectError || base != tc.expected {
+			t.Errorf("Expected %q, error: %v, Got %q, %v (%d)", tc.expected, tc.expectError, base, err, i)
+		}
+	}
+}
+
+func TestFindArtifacts(t *testing.T) {
+	b := validBuild()
+	b.Artifacts = []models.Artifact{
+		{OS: "linux", Architecture: "amd64", Kind: models.ArtifactBinary, URL: "http://s3.amazon.com/linux-amd64"},
+		{OS: "linux", Architecture: "arm64", Kind: models.ArtifactBinary, URL: "http://s3.amazon.com/linux-arm64"},
+		{OS: "linux", Architecture: "arm64", Kind: models.ArtifactDocker, URL: "docker://registry.example.com/build-service:arm64"},
+	}
+
+	testCases := []struct {
+		os, arch, kind string
+		expectedURLs   []string
+	}{
+		{"linux", "arm64", "binary", []string{"http://s3.amazon.com/linux-arm64"}},
+		{"Linux", "ARM64", "", []string{"http://s3.amazon.com/linux-arm64", "docker://registry.example.com/build-service:arm64"}},
+		{"darwin", "amd64", "binary", []string{}},
+		{"", "amd64", "", []string{"http://s3.amazon.com/linux-amd64", "http://s3.amazon.com/abcdefg"}},
+		{"windows", "386", "", []string{}},
+		{"", "", "jar", []string{}},
+	}
+
+	for i, tc := range testCases {
+		artifacts := b.FindArtifacts(tc.os, tc.arch, tc.kind)
+		urls := make([]string, 0)
+		for _, a := range artifacts {
+			urls = append(urls, a.URL)
+		}
+		if strings.Join(urls, " ") != strings.Join(tc.expectedURLs, " ") {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedURLs, urls, i)
+		}
+	}
+}
+
+func TestGetArtifacts(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+
+	branches := []string{"master", "master", "feature"}
+	for i, branch := range branches {
+		b := validBuild()
+		b.Version = fmt.Sprintf("2013062709174%d", i)
+		b.TimeStamp += int64(i)
+		b.Branch = branch
+		b.SetArtifact(models.Artifact{OS: "linux", Architecture: "arm64", Kind: models.ArtifactBinary, URL: "http://s3.amazon.com/" + b.Version})
+		repo.builds = append(repo.builds, &b)
+	}
+
+	path := "/builds/com.HailoOSS.kernel.build-service/"
+	testCases := []struct {
+		path            string
+		expectedStatus  int
+		expectedVersion string
+		expectedURLs    []string
+	}{
+		{path + "latest/artifacts?branch=master&os=linux&arch=arm64&kind=binary", http.StatusOK, "20130627091741", []string{"http://s3.amazon.com/20130627091741"}},
+		{path + "latest/artifacts?os=linux&arch=arm64", http.StatusOK, "20130627091742", []string{"http://s3.amazon.com/20130627091742"}},
+		{path + "20130627091740/artifacts?arch=amd64", http.StatusOK, "20130627091740", []string{"http://s3.amazon.com/abcdefg"}},
+		{path + "latest/artifacts?branch=master&os=darwin&arch=arm64&kind=docker", http.StatusNotFound, "", nil},
+		{path + "latest/artifacts?os=darwin&arch=amd64&kind=binary", http.StatusNotFound, "", nil},
+		{path + "latest/artifacts?arch=amd64&kind=binary", http.StatusOK, "20130627091742", []string{"http://s3.amazon.com/abcdefg"}},
+		{path + "latest/artifacts?branch=release", http.StatusNotFound, "", nil},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		found := new(models.BuildArtifacts)
+		json.NewDecoder(recorder.Body).Decode(found)
+		urls := make([]string, 0)
+		for _, a := range found.Artifacts {
+			urls = append(urls, a.URL)
+		}
+		if found.Version != tc.expectedVersion || strings.Join(urls, " ") != strings.Join(tc.expectedURLs, " ") {
+			t.Errorf("Expected %v %v, Got %v %v (%d)", tc.expectedVersion, tc.expectedURLs, found.Version, urls, i)
+		}
+	}
+}
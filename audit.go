@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	headerRequestID       = "X-Request-Id"
+	headerClientRequestID = "X-Client-Request-Id" // Set by requestIDHandler, never taken from the client
+	maxRequestIDLength    = 64
+	anonymousActor        = "anonymous"
+)
+
+// requestIDHandler gives every request an ID, and returns it in the response.
+// Any ID sent by the client or a proxy is kept separately, as it can be
+// anything.
+type requestIDHandler struct {
+	h http.Handler
+}
+
+func (rh requestIDHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	clientID := req.Header.Get(headerRequestID)
+	if len(clientID) > maxRequestIDLength {
+		clientID = clientID[:maxRequestIDLength]
+	}
+	req.Header.Set(headerClientRequestID, clientID)
+
+	var id string
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err == nil {
+		id = hex.EncodeToString(b)
+	}
+	req.Header.Set(headerRequestID, id)
+	w.Header().Set(headerRequestID, id)
+	rh.h.ServeHTTP(w, req)
+}
+
+// requestActor returns who made a request, for the audit log. Publishers are
+// only known once their signature has been verified, so they're named by the
+// handler which verified it.
+func requestActor(r *http.Request) string {
+	if client, _, err := requestPermissions(r); err == nil && client != "" {
+		return client
+	}
+	return anonymousActor
+}
+
+// publisherActor names a publisher whose signature was verified in the audit
+// log
+func publisherActor(publisher string) string {
+	return "publisher " + publisher
+}
+
+// newAuditEvent returns the audit event of a request changing a build, for
+// the repository to complete and save along with the change
+func newAuditEvent(r *http.Request, action, service, version string) *models.AuditEvent {
+	remoteAddr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddr = r.RemoteAddr
+	}
+
+	return &models.AuditEvent{
+		Time:            time.Now().Unix(),
+		Actor:           requestActor(r),
+		Action:          action,
+		Service:         service,
+		Version:         version,
+		RemoteAddr:      remoteAddr,
+		RequestID:       r.Header.Get(headerRequestID),
+		ClientRequestID: r.Header.Get(headerClientRequestID),
+	}
+}
+
+func getAuditEventsHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET AUDIT", r.URL)
+
+	if !authorize(rw, r, actionAdmin, "") {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := &models.AuditFilter{
+		Actor:   query.Get("actor"),
+		Action:  query.Get("action"),
+		Service: query.Get("service"),
+		Version: query.Get("version"),
+		Limit:   queryLimit(r),
+	}
+
+	for param, ts := range map[string]*int64{"since": &filter.Since, "until": &filter.Until} {
+		if query.Get(param) == "" {
+			continue
+		}
+		t, err := time.Parse("20060102150405", query.Get(param))
+		if err != nil {
+			logHTTPError(rw, fmt.Sprintf("Invalid %s %q, expected YYYYMMDDhhmmss", param, query.Get(param)), http.StatusBadRequest)
+			return
+		}
+		*ts = t.Unix()
+	}
+
+	events, err := buildRepo.GetAuditEvents(filter)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting audit events: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(events)
+}
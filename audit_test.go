@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestAuditLog(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+	commitRepo = newTestCommitRepo()
+
+	build, _ := json.Marshal(validBuild())
+	requests := []struct {
+		method string
+		path   string
+		body   []byte
+	}{
+		{"POST", "/builds", build},
+		{"PUT", "/builds/com.HailoOSS.kernel.build-service/20130627091746/coverage", []byte(`{"Coverage":{"dao":50}}`)},
+		{"DELETE", "/builds/com.HailoOSS.kernel.build-service/20130627091746", nil},
+	}
+	requestIDs := make([]string, len(requests))
+	for i, tc := range requests {
+		req, _ := http.NewRequest(tc.method, tc.path, bytes.NewReader(tc.body))
+		req.RemoteAddr = "10.0.0.1:51234"
+		req.Header.Set(headerRequestID, "req-1")
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected %v, Got %v: %s (%d)", http.StatusOK, recorder.Code, recorder.Body, i)
+		}
+		requestIDs[i] = recorder.Header().Get(headerRequestID)
+		if requestIDs[i] == "" || requestIDs[i] == "req-1" {
+			t.Errorf("Expected a request ID of our own to be returned, Got %q (%d)", requestIDs[i], i)
+		}
+	}
+
+	if repo.builds[0].Deleted == nil {
+		t.Fatalf("Expected the build to be deleted")
+	}
+
+	testCases := []struct {
+		path            string
+		expectedStatus  int
+		expectedActions []string
+	}{
+		{"/audit", http.StatusOK, []string{models.AuditDelete, models.AuditCoverage, models.AuditCreate}},
+		{"/audit?action=delete", http.StatusOK, []string{models.AuditDelete}},
+		{"/audit?service=com.HailoOSS.kernel.build-service&limit=2", http.StatusOK, []string{models.AuditDelete, models.AuditCoverage}},
+		{"/audit?actor=ops", http.StatusOK, []string{}},
+		{"/audit?since=20130627", http.StatusBadRequest, nil},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		var events []*models.AuditEvent
+		json.NewDecoder(recorder.Body).Decode(&events)
+		actions := make([]string, len(events))
+		for j, e := range events {
+			actions[j] = e.Action
+		}
+		if len(actions) != len(tc.expectedActions) {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedActions, actions, i)
+			continue
+		}
+		for j := range actions {
+			if actions[j] != tc.expectedActions[j] {
+				t.Errorf("Expected %v, Got %v (%d)", tc.expectedActions, actions, i)
+				break
+			}
+		}
+	}
+
+	deleted := repo.audit[2]
+	if deleted.Actor != anonymousActor || deleted.RemoteAddr != "10.0.0.1" || deleted.RequestID != requestIDs[2] || deleted.ClientRequestID != "req-1" {
+		t.Errorf("Expected anonymous from 10.0.0.1 with %s sent as req-1, Got %+v", requestIDs[2], deleted)
+	}
+	if deleted.Before == nil || deleted.Before.Coverage["dao"] != 50 {
+		t.Errorf("Expected the coverage before the build was deleted, Got %+v", deleted.Before)
+	}
+	if repo.audit[0].Before != nil {
+		t.Errorf("Expected no build before it was created, Got %+v", repo.audit[0].Before)
+	}
+}
+
+func TestAuditAuthorization(t *testing.T) {
+	defer func() { requireTokens = false }()
+	requireTokens = true
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	admin := addTestToken(t, repo, []string{actionAdmin}, nil)
+	reader := addTestToken(t, repo, []string{actionRead}, nil)
+
+	testCases := []struct {
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"/audit", reader, http.StatusForbidden},
+		{"/audit/", reader, http.StatusForbidden},
+		{"/auditx", reader, http.StatusForbidden},
+		{"/audit", "", http.StatusUnauthorized},
+		{"/audit", admin, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+}
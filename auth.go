@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The actions a client can be allowed to take
+const (
+	actionRead   = "read"          // Get builds and coverage
+	actionWrite  = "builds:write"  // Create builds and change their coverage or status
+	actionDelete = "builds:delete" // Delete builds
+	actionAdmin  = "admin"         // Manage API tokens and read the audit log and retention dry run
+)
+
+var validActions = []string{actionRead, actionWrite, actionDelete, actionAdmin}
+
+// Permissions are the actions a client may take, and the services it may take
+// them on
+type Permissions struct {
+	Actions  []string // read, builds:write, builds:delete or admin
+	Prefixes []string `json:",omitempty"` // The service name prefixes which may be written or deleted, any if empty
+}
+
+// Allows returns whether the action is permitted on the service. A blank
+// service checks the action alone.
+func (p *Permissions) Allows(action, service string) bool {
+	if p == nil || !contains(p.Actions, action) {
+		return false
+	}
+	if action == actionRead || service == "" || len(p.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(service, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientPolicy grants permissions to the clients presenting a certificate with
+// a subject
+type ClientPolicy struct {
+	Subject string // The common name or full distinguished name of the certificate, or blank for clients without one
+	Permissions
+}
+
+// ClientPolicies are the permissions of each client. If they're nil, clients
+// aren't authorized and may do anything.
+type ClientPolicies []*ClientPolicy
+
+// loadClientPolicies reads a JSON list of policies from a file
+func loadClientPolicies(path string) (ClientPolicies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policies := make(ClientPolicies, 0)
+	if err := json.NewDecoder(f).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Error decoding client policies: %v", err)
+	}
+
+	for _, p := range policies {
+		for _, action := range p.Actions {
+			if !contains(validActions, action) {
+				return nil, fmt.Errorf("Unknown action %q for %q", action, p.Subject)
+			}
+		}
+	}
+
+	return policies, nil
+}
+
+// loadClientCAs reads a bundle of PEM certificates to verify clients against
+func loadClientCAs(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// Find returns the policy for a verified client certificate, or for clients
+// without one if cert is nil
+func (cp ClientPolicies) Find(cert *x509.Certificate) *ClientPolicy {
+	for _, p := range cp {
+		if cert == nil {
+			if p.Subject == "" {
+				return p
+			}
+			continue
+		}
+		if p.Subject != "" && (p.Subject == cert.Subject.CommonName || p.Subject == cert.Subject.String()) {
+			return p
+		}
+	}
+	return nil
+}
+
+// requestPermissions returns who made a request and what they may do, or nil
+// permissions if they may do nothing. A bearer token takes precedence over a
+// client certificate.
+func requestPermissions(r *http.Request) (string, *Permissions, error) {
+	if token := bearerToken(r); token != "" {
+		return tokenPermissions(token)
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if p := clientPolicies.Find(cert); p != nil {
+			return cert.Subject.String(), &p.Permissions, nil
+		}
+	}
+
+	if p := clientPolicies.Find(nil); p != nil {
+		return "", &p.Permissions, nil
+	}
+	return "", nil, nil
+}
+
+// authEnabled returns whether clients are authorized
+func authEnabled() bool {
+	return clientPolicies != nil || requireTokens
+}
+
+// requestAction returns the action a request needs permission for. The
+// token, audit and retention handlers check for admin permission themselves,
+// as routes match by prefix and so any path starting /tokens, /audit or
+// /retention may reach them.
+// Badges are embedded in readmes, so need no permission with -public-badges.
+func requestAction(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/tokens") || strings.HasPrefix(r.URL.Path, "/audit") || strings.HasPrefix(r.URL.Path, "/retention") {
+		return ""
+	}
+	if publicBadges && strings.HasPrefix(r.URL.Path, "/badges/") && (r.Method == "GET" || r.Method == "HEAD") {
+		return ""
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		return actionRead
+	case "DELETE":
+		return actionDelete
+	case "OPTIONS":
+		return ""
+	}
+	return actionWrite
+}
+
+// authorize checks that the client may take the action on the service,
+// writing an error if not
+func authorize(rw http.ResponseWriter, r *http.Request, action, service string) bool {
+	if !authEnabled() || action == "" {
+		return true
+	}
+
+	client, perms, err := requestPermissions(r)
+	if err == errInvalidToken {
+		rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		logHTTPError(rw, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error authenticating: %v", err), http.StatusInternalServerError)
+		return false
+	}
+	if perms.Allows(action, service) {
+		return true
+	}
+
+	if client == "" {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		logHTTPError(rw, "Authentication required", http.StatusUnauthorized)
+	} else {
+		logHTTPError(rw, fmt.Sprintf("%s may not %s %s", client, action, service), http.StatusForbidden)
+	}
+	return false
+}
+
+// authHandler refuses requests from clients without permission for the
+// action of the request method. Handlers which act on a service check the
+// service name themselves.
+type authHandler struct {
+	h http.Handler
+}
+
+func (ah authHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !authorize(w, req, requestAction(req), "") {
+		log.Println("Refused", req.Method, req.URL)
+		return
+	}
+	ah.h.ServeHTTP(w, req)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPermissionsAllows(t *testing.T) {
+	p := &Permissions{
+		Actions:  []string{actionRead, actionWrite},
+		Prefixes: []string{"com.HailoOSS.kernel.", "com.HailoOSS.service."},
+	}
+
+	testCases := []struct {
+		perms    *Permissions
+		action   string
+		service  string
+		expected bool
+	}{
+		{p, actionRead, "com.example", true},
+		{p, actionWrite, "com.HailoOSS.kernel.build-service", true},
+		{p, actionWrite, "com.HailoOSS.other", false},
+		{p, actionWrite, "", true},
+		{p, actionDelete, "com.HailoOSS.kernel.build-service", false},
+		{&Permissions{Actions: []string{actionDelete}}, actionDelete, "com.example", true},
+		{nil, actionRead, "", false},
+	}
+
+	for i, tc := range testCases {
+		if allowed := tc.perms.Allows(tc.action, tc.service); allowed != tc.expected {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expected, allowed, i)
+		}
+	}
+}
+
+func TestLoadClientPolicies(t *testing.T) {
+	testCases := []struct {
+		data          string
+		expectedCount int
+		expectError   bool
+	}{
+		{`[{"Subject":"ci","Actions":["builds:write"],"Prefixes":["com.HailoOSS."]},{"Subject":"","Actions":["read"]}]`, 2, false},
+		{`[{"Subject":"ci","Actions":["explode"]}]`, 0, true},
+		{`not json`, 0, true},
+	}
+
+	for i, tc := range testCases {
+		f, err := ioutil.TempFile("", "policies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.data)
+		f.Close()
+
+		policies, err := loadClientPolicies(f.Name())
+		os.Remove(f.Name())
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+			continue
+		}
+		if len(policies) != tc.expectedCount {
+			t.Errorf("Expected %v policies, got %v (%d)", tc.expectedCount, len(policies), i)
+		}
+	}
+}
+
+// withClientCert marks the request as made with a verified client certificate
+func withClientCert(r *http.Request, commonName string) *http.Request {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName, Organization: []string{"Hailo"}}}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return r
+}
+
+func TestClientAuthorization(t *testing.T) {
+	defer func() { clientPolicies = nil }()
+	clientPolicies = ClientPolicies{
+		{Subject: "ci", Permissions: Permissions{Actions: []string{actionRead, actionWrite}, Prefixes: []string{"com.HailoOSS.kernel."}}},
+		{Subject: "CN=ops,O=Hailo", Permissions: Permissions{Actions: []string{actionRead, actionDelete}}},
+		{Subject: "", Permissions: Permissions{Actions: []string{actionRead}}},
+	}
+
+	build := validBuild()
+	other := validBuild()
+	other.Name = "com.HailoOSS.other"
+
+	body := func(b interface{}) []byte {
+		data, _ := json.Marshal(b)
+		return data
+	}
+
+	testCases := []struct {
+		method         string
+		path           string
+		body           []byte
+		client         string
+		expectedStatus int
+	}{
+		{"GET", "/builds", nil, "", http.StatusOK},
+		{"POST", "/builds", body(build), "", http.StatusUnauthorized},
+		{"POST", "/builds", body(build), "ci", http.StatusOK},
+		{"POST", "/builds", body(other), "ci", http.StatusForbidden},
+		{"POST", "/builds", body(build), "ops", http.StatusForbidden},
+		{"POST", "/builds", body(build), "stranger", http.StatusUnauthorized},
+		{"DELETE", "/builds/com.HailoOSS.kernel.build-service/20130627091746", nil, "ci", http.StatusForbidden},
+		{"DELETE", "/builds/com.HailoOSS.kernel.build-service/20130627091746", nil, "ops", http.StatusOK},
+		{"PUT", "/builds/com.HailoOSS.other/20130627091746/coverage", []byte(`{}`), "ci", http.StatusForbidden},
+	}
+
+	for i, tc := range testCases {
+		buildRepo = newTestRepo()
+
+		req, _ := http.NewRequest(tc.method, tc.path, bytes.NewReader(tc.body))
+		if tc.client != "" {
+			withClientCert(req, tc.client)
+		}
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+}
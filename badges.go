@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	badgeFormatSVG  = "svg"
+	badgeFormatJSON = "json"
+
+	badgeCacheControl        = "public, max-age=300"
+	badgeUnknownCacheControl = "public, max-age=60"
+
+	badgeCharWidth = 7  // Approximate width of a character in pixels
+	badgePadding   = 10 // Horizontal padding either side of the text
+)
+
+// badgeColours maps shields.io colour names to their hex values
+var badgeColours = map[string]string{
+	"brightgreen": "#4c1",
+	"green":       "#97ca00",
+	"yellowgreen": "#a4a61d",
+	"yellow":      "#dfb317",
+	"orange":      "#fe7d37",
+	"red":         "#e05d44",
+	"blue":        "#007ec6",
+	"lightgrey":   "#9f9f9f",
+}
+
+// coverageThresholds are the minimum coverage for each badge colour, highest first
+var coverageThresholds = []struct {
+	min    float64
+	colour string
+}{
+	{90, "brightgreen"},
+	{75, "green"},
+	{60, "yellowgreen"},
+	{40, "yellow"},
+	{20, "orange"},
+	{0, "red"},
+}
+
+// badge is the content of a badge, which is rendered as SVG or as JSON in the
+// shields.io endpoint format
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// coverageColour returns the badge colour for a coverage percentage
+func coverageColour(percentage float64) string {
+	for _, t := range coverageThresholds {
+		if percentage >= t.min {
+			return t.colour
+		}
+	}
+	return "red"
+}
+
+func coverageBadge(b *models.Build) badge {
+	if b == nil || len(b.Coverage) == 0 {
+		return badge{Label: "coverage", Message: "unknown", Color: "lightgrey"}
+	}
+
+	total := models.AggregateCoverage(b.Coverages())
+	return badge{
+		Label:   "coverage",
+		Message: strconv.FormatFloat(total, 'f', -1, 64) + "%",
+		Color:   coverageColour(total),
+	}
+}
+
+func versionBadge(b *models.Build) badge {
+	if b == nil {
+		return badge{Label: "version", Message: "unknown", Color: "lightgrey"}
+	}
+	return badge{Label: "version", Message: b.Version, Color: "blue"}
+}
+
+// renderBadge writes a flat badge as SVG
+func renderBadge(b badge) string {
+	labelWidth := len(b.Label)*badgeCharWidth + 2*badgePadding
+	messageWidth := len(b.Message)*badgeCharWidth + 2*badgePadding
+	width := labelWidth + messageWidth
+
+	colour, ok := badgeColours[b.Color]
+	if !ok {
+		colour = badgeColours["lightgrey"]
+	}
+	label := html.EscapeString(b.Label)
+	message := html.EscapeString(b.Message)
+
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text>`+
+		`</g></svg>`,
+		width, labelWidth, messageWidth, label, message, colour, labelWidth/2, labelWidth+messageWidth/2)
+}
+
+// badgeHandler returns a handler serving a badge for the latest build of a
+// service, optionally on the branch given by ?branch=
+func badgeHandler(build func(*models.Build) badge, format string) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		log.Println("GET BADGE", r.URL)
+
+		serviceName := r.URL.Query().Get(":name")
+		branch := r.URL.Query().Get("branch")
+
+		latest, err := buildRepo.GetLatest(serviceName, branch)
+		if err != nil {
+			logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		b := build(latest)
+		b.SchemaVersion = 1
+
+		if latest == nil {
+			rw.Header().Set("Cache-Control", badgeUnknownCacheControl)
+		} else {
+			rw.Header().Set("Cache-Control", badgeCacheControl)
+		}
+
+		if format == badgeFormatJSON {
+			rw.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(rw).Encode(b)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(rw, renderBadge(b))
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCoverageColour(t *testing.T) {
+	testCases := []struct {
+		percentage float64
+		expected   string
+	}{
+		{100, "brightgreen"},
+		{90, "brightgreen"},
+		{89.99, "green"},
+		{60, "yellowgreen"},
+		{45, "yellow"},
+		{20, "orange"},
+		{0, "red"},
+	}
+
+	for _, tc := range testCases {
+		if colour := coverageColour(tc.percentage); colour != tc.expected {
+			t.Errorf("Expected %v for %v, got %v", tc.expected, tc.percentage, colour)
+		}
+	}
+}
+
+func TestBadges(t *testing.T) {
+	master := validBuild()
+
+	feature := validBuild()
+	feature.Branch = "feature"
+	feature.Version = "20130628000000"
+	feature.TimeStamp = master.TimeStamp + 1
+	feature.Statements = nil
+	feature.Coverage = map[string]float64{"dao": 95}
+
+	testCases := []struct {
+		handler         http.HandlerFunc
+		path            string
+		expected        badge
+		expectedCaching string
+	}{
+		{badgeHandler(coverageBadge, badgeFormatJSON), "/badges?:name=com.HailoOSS.kernel.build-service", badge{1, "coverage", "95%", "brightgreen"}, badgeCacheControl},
+		{badgeHandler(coverageBadge, badgeFormatJSON), "/badges?:name=com.HailoOSS.kernel.build-service&branch=master", badge{1, "coverage", "17.81%", "red"}, badgeCacheControl},
+		{badgeHandler(versionBadge, badgeFormatJSON), "/badges?:name=com.HailoOSS.kernel.build-service&branch=master", badge{1, "version", "20130627091746", "blue"}, badgeCacheControl},
+		{badgeHandler(versionBadge, badgeFormatJSON), "/badges?:name=com.test", badge{1, "version", "unknown", "lightgrey"}, badgeUnknownCacheControl},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+
+		repo := newTestRepo()
+		repo.builds = append(repo.builds, &master, &feature)
+		buildRepo = repo
+
+		tc.handler(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected %v, Got %v (%d)", http.StatusOK, recorder.Code, i)
+			continue
+		}
+		if cc := recorder.Header().Get("Cache-Control"); cc != tc.expectedCaching {
+			t.Errorf("Expected Cache-Control %v, got %v (%d)", tc.expectedCaching, cc, i)
+		}
+
+		var b badge
+		if err := json.NewDecoder(recorder.Body).Decode(&b); err != nil {
+			t.Errorf("Error decoding badge: %v (%d)", err, i)
+			continue
+		}
+		if b != tc.expected {
+			t.Errorf("Expected %+v, got %+v (%d)", tc.expected, b, i)
+		}
+	}
+}
+
+func TestBadgeSVG(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/badges?:name=com.HailoOSS.kernel.build-service", nil)
+
+	b := validBuild()
+	repo := newTestRepo()
+	repo.builds = append(repo.builds, &b)
+	buildRepo = repo
+
+	badgeHandler(coverageBadge, badgeFormatSVG)(recorder, req)
+
+	if ct := recorder.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Expected SVG content type, got %v", ct)
+	}
+	body := recorder.Body.String()
+	if !strings.HasPrefix(body, "<svg") || !strings.Contains(body, "17.81%") || !strings.Contains(body, badgeColours["red"]) {
+		t.Errorf("Unexpected badge: %v", body)
+	}
+}
+
+func TestRenderBadgeEscapes(t *testing.T) {
+	svg := renderBadge(badge{Label: "version", Message: "<script>", Color: "blue"})
+	if strings.Contains(svg, "<script>") {
+		t.Errorf("Expected message to be escaped: %v", svg)
+	}
+}
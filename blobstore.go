@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errBlobNotFound = errors.New("Blob not found")
+
+// Blob is the content of a stored blob, which can be read from any offset
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// BlobStore defines the interface required by an artifact store. Keys are
+// slash separated paths.
+type BlobStore interface {
+	// Put stores the content of r, replacing any blob with the key, and
+	// returns its size
+	Put(key string, r io.Reader) (int64, error)
+	// Open returns a blob and when it was stored, or errBlobNotFound
+	Open(key string) (Blob, time.Time, error)
+	// Delete removes a blob, if there is one
+	Delete(key string) error
+}
+
+// fileBlobStore stores blobs as files in a directory
+type fileBlobStore struct {
+	dir string
+}
+
+func newFileBlobStore(dir string) (*fileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating artifact directory: %v", err)
+	}
+	return &fileBlobStore{dir: filepath.Clean(dir)}, nil
+}
+
+// path returns the file of a key, refusing keys outside of the directory
+func (s *fileBlobStore) path(key string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("Invalid blob key %q", key)
+	}
+	return path, nil
+}
+
+// Put writes the blob to a temporary file which replaces the existing one
+// once it's complete, so a failed upload never leaves a partial blob
+func (s *fileBlobStore) Put(key string, r io.Reader) (int64, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".upload")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (s *fileBlobStore) Open(key string) (Blob, time.Time, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, errBlobNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, err
+	}
+	return f, fi.ModTime(), nil
+}
+
+func (s *fileBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
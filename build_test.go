@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/HailoOSS/build-service/models"
+	"github.com/HailoOSS/build-service/validate"
 )
 
 func init() {
@@ -24,14 +25,23 @@ func validBuild() models.Build {
 		GoVersion:    "1.1.1",
 		SourceURL:    "https://github.com/HailoOSS/build-service/commit/53d6db9a88494e948b64415f53e1bf9da7efcc4b",
 		BinaryURL:    "http://s3.amazon.com/abcdefg",
+		BinarySHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		BinarySize:   4,
 		Version:      "20130627091746",
 		Language:     "Go",
 		Name:         "com.HailoOSS.kernel.build-service",
 		Branch:       "master",
 		TimeStamp:    1372346773,
-		Coverage: map[string]float64{
-			"dao":    12.3,
-			"domain": 100.0,
+		Status:       models.StatusSucceeded,
+		CoverageReport: models.CoverageReport{
+			Coverage: map[string]float64{
+				"dao":    12.3,
+				"domain": 100.0,
+			},
+			Statements: map[string]models.StatementCount{
+				"dao":    {Covered: 37, Total: 300},
+				"domain": {Covered: 20, Total: 20},
+			},
 		},
 		Dependencies: map[string]string{
 			"github.com/HailoOSS/go-server-layer": "e6dc54ee3618c7b354dccdb6425cf4f82e07423c",
@@ -45,12 +55,60 @@ func TestValidateBuild(t *testing.T) {
 	missingHostname := validBuild
 	missingHostname.Hostname = ""
 
+	invalidVersion := validBuild
+	invalidVersion.Version = "123"
+
+	unknownLanguage := validBuild
+	unknownLanguage.Language = "Ruby"
+
+	invalidURLs := validBuild
+	invalidURLs.SourceURL = "github.com/HailoOSS/build-service"
+	invalidURLs.BinaryURL = "ftp://s3.amazon.com/abcdefg"
+
+	invalidCoverage := validBuild
+	invalidCoverage.CoverageReport = models.CoverageReport{
+		Coverage: map[string]float64{"dao": 120, "domain": -1},
+		Files:    []models.FileCoverage{{FileName: "dao/dao.go", Percentage: 101}},
+	}
+
+	invalidDependencies := validBuild
+	invalidDependencies.Dependencies = map[string]string{
+		"github.com/HailoOSS/go-server-layer": "e6dc54e",
+		"not an import path":                  "e6dc54ee3618c7b354dccdb6425cf4f82e07423c",
+	}
+
+	binary := models.Artifact{OS: "linux", Architecture: "amd64", Kind: models.ArtifactBinary, URL: "http://s3.amazon.com/abcdefg", SHA256: validBuild.BinarySHA256, Size: 4}
+
+	multiArch := validBuild
+	multiArch.BinaryURL, multiArch.BinarySHA256, multiArch.BinarySize = "", "", 0
+	arm64 := binary
+	arm64.Architecture = "arm64"
+	image := binary
+	image.Kind, image.URL, image.Size = models.ArtifactDocker, "docker://registry.example.com/build-service:20130627091746", 0
+	multiArch.Artifacts = []models.Artifact{binary, arm64, image}
+
+	invalidArtifacts := validBuild
+	duplicate := binary
+	duplicate.OS = "Linux"
+	unknownKind := binary
+	unknownKind.Kind = "zip"
+	invalidPlatform := binary
+	invalidPlatform.OS, invalidPlatform.SHA256, invalidPlatform.Size = "linux/x", "", 0
+	invalidArtifacts.Artifacts = []models.Artifact{binary, duplicate, unknownKind, invalidPlatform}
+
 	testCases := []struct {
 		build              *models.Build
 		expectedErrorCount int
 	}{
 		{&validBuild, 0},
 		{&missingHostname, 1},
+		{&invalidVersion, 1},
+		{&unknownLanguage, 1},
+		{&invalidURLs, 2},
+		{&invalidCoverage, 3},
+		{&invalidDependencies, 2},
+		{&multiArch, 0},
+		{&invalidArtifacts, 5},
 	}
 
 	for _, tc := range testCases {
@@ -118,6 +176,122 @@ func TestCreateBuild(t *testing.T) {
 	}
 }
 
+func TestCreateInvalidBuild(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	sampleBuild := validBuild()
+	sampleBuild.Hostname = ""
+	sampleBuild.Language = "Ruby"
+
+	data, _ := json.Marshal(sampleBuild)
+	req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(data))
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	createBuildHandler(recorder, req)
+
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected %v, Got %v", http.StatusUnprocessableEntity, recorder.Code)
+	}
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Expected a problem document, got %v", ct)
+	}
+	if len(repo.builds) != 0 {
+		t.Errorf("Expected no builds to be saved, got %v", len(repo.builds))
+	}
+
+	p := new(problem)
+	if err := json.NewDecoder(recorder.Body).Decode(p); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*validate.FieldError{
+		{Field: "Hostname", Path: "Hostname", Rule: "nonblank", Message: "Hostname cannot be blank"},
+		{Field: "Language", Path: "Language", Rule: "oneof", Message: "Language must be one of Go, Java"},
+	}
+	if p.Status != http.StatusUnprocessableEntity || !reflect.DeepEqual(p.Errors, expected) {
+		t.Errorf("Unexpected problem %+v", p)
+	}
+}
+
+func TestCreateBuildWithCoverProfile(t *testing.T) {
+	testCases := []struct {
+		profile        string
+		expectedStatus int
+		expectedFiles  []models.FileCoverage
+	}{
+		{
+			"mode: set\ngithub.com/HailoOSS/build-service/main.go:77.62,80.2 2 1\ngithub.com/HailoOSS/build-service/main.go:82.61,86.16 2 0\n",
+			http.StatusOK,
+			[]models.FileCoverage{
+				{FileName: "github.com/HailoOSS/build-service/main.go", Covered: 2, Statements: 4, Percentage: 50},
+			},
+		},
+		{"mode: set\nnonsense\n", http.StatusBadRequest, nil},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+
+		sampleBuild := validBuild()
+		sampleBuild.CoverProfile = tc.profile
+
+		data, _ := json.Marshal(sampleBuild)
+		req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(data))
+
+		repo := newTestRepo()
+		buildRepo = repo
+
+		createBuildHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		b := repo.builds[0]
+		if b.CoverProfile != "" {
+			t.Errorf("Expected the raw cover profile to be dropped (%d)", i)
+		}
+		if !reflect.DeepEqual(b.Files, tc.expectedFiles) {
+			t.Errorf("Expected files %+v, got %+v (%d)", tc.expectedFiles, b.Files, i)
+		}
+	}
+}
+
+func TestCoverProfileTotalCoverage(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+
+	sampleBuild := validBuild()
+	sampleBuild.Coverage, sampleBuild.Statements = nil, nil
+	sampleBuild.CoverProfile = "mode: set\n" +
+		"github.com/HailoOSS/build-service/main.go:77.62,80.2 2 1\n" +
+		"github.com/HailoOSS/build-service/main.go:82.61,86.16 2 0\n" +
+		"github.com/HailoOSS/build-service/validate/validate.go:12.39,19.28 8 1\n"
+
+	data, _ := json.Marshal(sampleBuild)
+	req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(data))
+	recorder := httptest.NewRecorder()
+	createBuildHandler(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v, Got %v: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+
+	b, err := repo.GetVersion(sampleBuild.Name, sampleBuild.Version)
+	if err != nil || b == nil {
+		t.Fatalf("Expected the build, Got %v %v", b, err)
+	}
+	// 10 of 12 statements, rather than the mean of 50% and 100%
+	if total := models.AggregateCoverage(b.Coverages()); total != 83.33 {
+		t.Errorf("Expected 83.33, Got %v (%v)", total, b.Statements)
+	}
+}
+
 func TestGetBuilds(t *testing.T) {
 	testCases := []struct {
 		repo           *memoryRepo
@@ -165,6 +339,157 @@ func TestGetCoverage(t *testing.T) {
 	}
 }
 
+func TestSetCoverage(t *testing.T) {
+	testCases := []struct {
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746", `{"Coverage":{"dao":50}}`, http.StatusOK},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=123", `{"Coverage":{"dao":50}}`, http.StatusNotFound},
+		{"/builds?:name=&:version=123", `{}`, http.StatusBadRequest},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746", `{"CoverProfile":"nonsense"}`, http.StatusBadRequest},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746", `not json`, http.StatusBadRequest},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746", `{"Coverage":{"dao":150}}`, http.StatusUnprocessableEntity},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", tc.path, bytes.NewReader([]byte(tc.body)))
+
+		b := validBuild()
+		repo := newTestRepo()
+		repo.builds = append(repo.builds, &b)
+		buildRepo = repo
+
+		setCoverageHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		expected := map[string]float64{"dao": 50}
+		if !reflect.DeepEqual(b.Coverage, expected) {
+			t.Errorf("Expected coverage %v, got %v (%d)", expected, b.Coverage, i)
+		}
+	}
+}
+
+func TestDiffCoverage(t *testing.T) {
+	build := validBuild()
+	build.Statements = nil
+	build.Coverage = map[string]float64{"dao": 20, "domain": 100, "handler": 60}
+
+	against := validBuild()
+	against.Version = "20130627000000"
+	against.Statements = nil
+	against.Coverage = map[string]float64{"dao": 12.3, "domain": 100, "util": 0}
+
+	expected := &models.CoverageDiff{
+		Name:                 "com.HailoOSS.kernel.build-service",
+		Version:              "20130627091746",
+		Against:              "20130627000000",
+		TotalCoverage:        60,
+		AgainstTotalCoverage: 37.43,
+		TotalChange:          22.57,
+		Packages: []models.PackageDiff{
+			{PackageName: "dao", Percentage: 20, AgainstPercentage: 12.3, Change: 7.7},
+			{PackageName: "domain", Percentage: 100, AgainstPercentage: 100, Change: 0},
+		},
+		Added:   []models.Coverage{{PackageName: "handler", Percentage: 60}},
+		Removed: []models.Coverage{{PackageName: "util", Percentage: 0}},
+	}
+
+	if diff := models.DiffCoverage(&build, &against); !reflect.DeepEqual(diff, expected) {
+		t.Errorf("\nExpected:%+v\nGot     :%+v", expected, diff)
+	}
+}
+
+func TestGetCoverageDiff(t *testing.T) {
+	master := validBuild()
+	master.Version = "20130627000000"
+	master.TimeStamp = 1372346000
+
+	feature := validBuild()
+	feature.Branch = "feature"
+	feature.Coverage = map[string]float64{"dao": 20}
+
+	oldFeature := validBuild()
+	oldFeature.Version = "20130627050000"
+	oldFeature.TimeStamp = 1372346500
+	oldFeature.Branch = "feature"
+
+	testCases := []struct {
+		path            string
+		expectedStatus  int
+		expectedAgainst string
+	}{
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746", http.StatusOK, "20130627000000"},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746&against=latest&branch=feature", http.StatusOK, "20130627050000"},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627000000", http.StatusNotFound, ""},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746&against=20130627000000", http.StatusOK, "20130627000000"},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746&against=123", http.StatusNotFound, ""},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&:version=123", http.StatusNotFound, ""},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+
+		repo := newTestRepo()
+		repo.builds = append(repo.builds, &master, &feature, &oldFeature)
+		buildRepo = repo
+
+		getCoverageDiffHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		diff := new(models.CoverageDiff)
+		json.NewDecoder(recorder.Body).Decode(diff)
+		if diff.Against != tc.expectedAgainst {
+			t.Errorf("Expected to compare against %v, got %v (%d)", tc.expectedAgainst, diff.Against, i)
+		}
+	}
+}
+
+func TestGetFileAndFunctionCoverage(t *testing.T) {
+	testCases := []struct {
+		handler        http.HandlerFunc
+		expectedMethod string
+	}{
+		{getFileCoverageHandler, "GetFileCoverage"},
+		{getFunctionCoverageHandler, "GetFunctionCoverage"},
+	}
+
+	for _, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/builds?:name=com.test&:version=123&limit=5", nil)
+
+		repo := newTestRepo()
+		buildRepo = repo
+
+		tc.handler(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected %v, Got %v", http.StatusOK, recorder.Code)
+		}
+
+		if repo.called != tc.expectedMethod {
+			t.Errorf("Expected %v to have been called. Got: %v", tc.expectedMethod, repo.called)
+		}
+	}
+}
+
 func TestGetCoverageTrend(t *testing.T) {
 	recorder := httptest.NewRecorder()
 
@@ -221,9 +546,10 @@ func TestGroupCoverageRows(t *testing.T) {
 	}
 	expected := models.CoverageSnapshots{
 		{
-			Timestamp: 312234234,
-			Branch:    "master",
-			Version:   "123",
+			Timestamp:     312234234,
+			Branch:        "master",
+			Version:       "123",
+			TotalCoverage: 55.00,
 			Coverages: []models.Coverage{
 				models.Coverage{
 					PackageName: "pkg1",
@@ -236,9 +562,10 @@ func TestGroupCoverageRows(t *testing.T) {
 			},
 		},
 		{
-			Timestamp: 312234235,
-			Branch:    "master",
-			Version:   "124",
+			Timestamp:     312234235,
+			Branch:        "master",
+			Version:       "124",
+			TotalCoverage: 56.00,
 			Coverages: []models.Coverage{
 				models.Coverage{
 					PackageName: "pkg1",
@@ -252,6 +579,30 @@ func TestGroupCoverageRows(t *testing.T) {
 		},
 	}
 
+	// A build on another branch in the same second shouldn't be merged
+	coverageRows = append(coverageRows, coverageRow{
+		service:    "service",
+		version:    "125",
+		branch:     "feature",
+		pkg:        "pkg1",
+		percentage: 10.00,
+		timestamp:  312234235,
+	})
+	expected = append(expected, models.CoverageSnapshot{
+		Timestamp:     312234235,
+		Branch:        "master",
+		Version:       "124",
+		TotalCoverage: 56.00,
+		Coverages:     expected[1].Coverages,
+	})
+	expected[1] = models.CoverageSnapshot{
+		Timestamp:     312234235,
+		Branch:        "feature",
+		Version:       "125",
+		TotalCoverage: 10.00,
+		Coverages:     []models.Coverage{{PackageName: "pkg1", Percentage: 10.00}},
+	}
+
 	actual := groupCoverageRows(coverageRows)
 	if !reflect.DeepEqual(actual, expected) {
 		t.Error("Expected:")
@@ -261,6 +612,49 @@ func TestGroupCoverageRows(t *testing.T) {
 	}
 }
 
+func TestAggregateCoverage(t *testing.T) {
+	testCases := []struct {
+		coverages []models.Coverage
+		expected  float64
+	}{
+		{nil, 0},
+		{
+			// No statement counts, so the mean percentage is used
+			[]models.Coverage{
+				{PackageName: "pkg1", Percentage: 10.00},
+				{PackageName: "pkg2", Percentage: 100.00},
+			},
+			55.00,
+		},
+		{
+			// A small, fully covered package shouldn't dominate
+			[]models.Coverage{
+				{PackageName: "pkg1", Percentage: 10.00, Covered: 90, Statements: 900},
+				{PackageName: "pkg2", Percentage: 100.00, Covered: 100, Statements: 100},
+			},
+			19.00,
+		},
+		{
+			[]models.Coverage{
+				{PackageName: "pkg1", Percentage: 33.33, Covered: 1, Statements: 3},
+			},
+			33.33,
+		},
+	}
+
+	for i, tc := range testCases {
+		actual := models.AggregateCoverage(tc.coverages)
+		if actual != tc.expected {
+			t.Errorf("Expected %v, got %v (%d)", tc.expected, actual, i)
+		}
+	}
+
+	b := validBuild()
+	if total := models.AggregateCoverage(b.Coverages()); total != 17.81 {
+		t.Errorf("Expected build total of 17.81, got %v", total)
+	}
+}
+
 func TestDeleteBuild(t *testing.T) {
 	testCases := []struct {
 		path           string
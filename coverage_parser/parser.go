@@ -2,9 +2,11 @@ package coverage_parser
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"regexp"
 	"strconv"
@@ -80,7 +82,28 @@ func writeCoverage(w io.Writer, coverage []models.Coverage) error {
 }
 
 func CoverageMain() {
-	coverage, err := getCoverage(os.Stdin)
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v", err)
+		os.Exit(1)
+	}
+
+	// A cover profile has the statement counts too, so both are written in
+	// the format of a build's Coverage and Statements
+	if detectFormat(data) == FormatProfile {
+		report := new(models.CoverageReport)
+		if err := ParseReport(bytes.NewReader(data), FormatProfile, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			os.Exit(1)
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(models.CoverageReport{Coverage: report.Coverage, Statements: report.Statements}); err != nil {
+			fmt.Fprintf(os.Stderr, "%v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	coverage, err := getCoverage(bytes.NewReader(data))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v", err)
 		os.Exit(1)
@@ -0,0 +1,166 @@
+package coverage_parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+var (
+	regProfileLine = regexp.MustCompile(`^(.+):([0-9]+\.[0-9]+,[0-9]+\.[0-9]+) ([0-9]+) ([0-9]+)$`)
+	regFuncLine    = regexp.MustCompile(`^(.+):([0-9]+):\s+(\S+)\s+([0-9]{1,3}\.[0-9])%$`)
+)
+
+// profileBlock is a single block of a cover profile
+type profileBlock struct {
+	fileName   string
+	statements int64
+	count      int64
+}
+
+// ParseProfile reads a Go cover profile, as written by go test -coverprofile,
+// and returns the coverage of each file in it. Blocks which appear more than
+// once (e.g. when profiles have been concatenated) are only counted once.
+func ParseProfile(from io.Reader) ([]models.FileCoverage, error) {
+	blocks := make(map[string]profileBlock)
+
+	scanner := bufio.NewScanner(from)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		match := regProfileLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("Couldn't parse profile line: %q", line)
+		}
+		statements, _ := strconv.ParseInt(match[3], 10, 64)
+		count, _ := strconv.ParseInt(match[4], 10, 64)
+
+		key := match[1] + ":" + match[2]
+		if b, ok := blocks[key]; ok && b.count >= count {
+			continue
+		}
+		blocks[key] = profileBlock{
+			fileName:   match[1],
+			statements: statements,
+			count:      count,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	byFile := make(map[string]*models.FileCoverage)
+	for _, b := range blocks {
+		fc, ok := byFile[b.fileName]
+		if !ok {
+			fc = &models.FileCoverage{FileName: b.fileName}
+			byFile[b.fileName] = fc
+		}
+		fc.Statements += b.statements
+		if b.count > 0 {
+			fc.Covered += b.statements
+		}
+	}
+
+	files := make([]models.FileCoverage, 0, len(byFile))
+	for _, fc := range byFile {
+		fc.Percentage = models.Percentage(fc.Covered, fc.Statements)
+		files = append(files, *fc)
+	}
+	sort.Sort(byFileName(files))
+
+	return files, nil
+}
+
+// AddPackageCoverage rolls the coverage of files up to their packages, adding
+// the statement counts and percentage of each package to the report. Packages
+// are named relative to the root package of the service, which is main, as
+// they are in the output of go test -cover.
+func AddPackageCoverage(report *models.CoverageReport, files []models.FileCoverage) {
+	if len(files) == 0 {
+		return
+	}
+
+	root := path.Dir(files[0].FileName)
+	for _, f := range files[1:] {
+		dir := path.Dir(f.FileName)
+		for root != "." && root != "/" && dir != root && !strings.HasPrefix(dir, root+"/") {
+			root = path.Dir(root)
+		}
+	}
+
+	if report.Coverage == nil {
+		report.Coverage = make(map[string]float64)
+	}
+	if report.Statements == nil {
+		report.Statements = make(map[string]models.StatementCount)
+	}
+
+	counts := make(map[string]models.StatementCount)
+	for _, f := range files {
+		pkg := strings.TrimPrefix(strings.TrimPrefix(path.Dir(f.FileName), root), "/")
+		if pkg == "" {
+			pkg = "main"
+		}
+		c := counts[pkg]
+		c.Covered += f.Covered
+		c.Total += f.Statements
+		counts[pkg] = c
+	}
+	for pkg, c := range counts {
+		report.Statements[pkg] = c
+		report.Coverage[pkg] = models.Percentage(c.Covered, c.Total)
+	}
+}
+
+type byFileName []models.FileCoverage
+
+func (f byFileName) Len() int           { return len(f) }
+func (f byFileName) Less(i, j int) bool { return f[i].FileName < f[j].FileName }
+func (f byFileName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// ParseFuncs reads the output of go tool cover -func and returns the coverage
+// of each function. The total line is ignored.
+func ParseFuncs(from io.Reader) ([]models.FunctionCoverage, error) {
+	functions := make([]models.FunctionCoverage, 0)
+
+	scanner := bufio.NewScanner(from)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "total:") {
+			continue
+		}
+
+		match := regFuncLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("Couldn't parse function line: %q", line)
+		}
+		lineNo, _ := strconv.Atoi(match[2])
+		perc, err := strconv.ParseFloat(match[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse percentage: %v", err)
+		}
+
+		functions = append(functions, models.FunctionCoverage{
+			FileName:     match[1],
+			Line:         lineNo,
+			FunctionName: match[3],
+			Percentage:   perc,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return functions, nil
+}
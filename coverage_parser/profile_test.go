@@ -0,0 +1,62 @@
+package coverage_parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestParseProfile(t *testing.T) {
+	files, err := ParseProfile(strings.NewReader(testProfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []models.FileCoverage{
+		{FileName: "github.com/HailoOSS/build-service/main.go", Covered: 3, Statements: 5, Percentage: 60},
+		{FileName: "github.com/HailoOSS/build-service/validate/validate.go", Covered: 4, Statements: 4, Percentage: 100},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Expected: %+v, Got: %+v", expected, files)
+	}
+}
+
+func TestParseProfileInvalid(t *testing.T) {
+	_, err := ParseProfile(strings.NewReader("mode: set\nnot a profile line"))
+	if err == nil {
+		t.Error("Expected an error parsing an invalid profile")
+	}
+}
+
+func TestParseFuncs(t *testing.T) {
+	functions, err := ParseFuncs(strings.NewReader(testFuncs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []models.FunctionCoverage{
+		{FileName: "github.com/HailoOSS/build-service/main.go", Line: 77, FunctionName: "logHTTPError", Percentage: 100},
+		{FileName: "github.com/HailoOSS/build-service/main.go", Line: 82, FunctionName: "getNamesHandler", Percentage: 77.8},
+		{FileName: "github.com/HailoOSS/build-service/sqlrepo.go", Line: 35, FunctionName: "Connect", Percentage: 0},
+	}
+	if !reflect.DeepEqual(functions, expected) {
+		t.Errorf("Expected: %+v, Got: %+v", expected, functions)
+	}
+}
+
+var testProfile = `mode: set
+github.com/HailoOSS/build-service/main.go:77.62,80.2 2 1
+github.com/HailoOSS/build-service/main.go:82.61,86.16 1 1
+github.com/HailoOSS/build-service/main.go:86.16,90.3 2 0
+github.com/HailoOSS/build-service/validate/validate.go:12.39,19.28 3 1
+github.com/HailoOSS/build-service/validate/validate.go:19.28,22.3 1 0
+github.com/HailoOSS/build-service/validate/validate.go:19.28,22.3 1 1
+`
+
+var testFuncs = `github.com/HailoOSS/build-service/main.go:77:		logHTTPError		100.0%
+github.com/HailoOSS/build-service/main.go:82:		getNamesHandler		77.8%
+github.com/HailoOSS/build-service/sqlrepo.go:35:	Connect			0.0%
+total:							(statements)		36.1%
+`
@@ -0,0 +1,203 @@
+package coverage_parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	FormatAuto    = "auto"    // Detect the format from the input
+	FormatTest    = "test"    // The output of go test -cover
+	FormatProfile = "profile" // A cover profile from go test -coverprofile
+	FormatFunc    = "func"    // The output of go tool cover -func
+
+	defaultServer        = "http://localhost:3000"
+	defaultRetries       = 3
+	defaultRetryWait     = 2 * time.Second
+	defaultUploadTimeout = 30 * time.Second
+)
+
+// detectFormat guesses the format of coverage output from its first line
+func detectFormat(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode:") {
+			return FormatProfile
+		}
+		if regFuncLine.MatchString(line) {
+			return FormatFunc
+		}
+		return FormatTest
+	}
+	return FormatTest
+}
+
+// ParseReport reads coverage in any of the supported formats and adds it to
+// the report
+func ParseReport(from io.Reader, format string, report *models.CoverageReport) error {
+	data, err := ioutil.ReadAll(from)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatAuto {
+		format = detectFormat(data)
+	}
+
+	switch format {
+	case FormatTest:
+		coverage, err := getCoverage(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if report.Coverage == nil {
+			report.Coverage = make(map[string]float64)
+		}
+		for _, c := range coverage {
+			report.Coverage[c.PackageName] = c.Percentage
+		}
+	case FormatProfile:
+		files, err := ParseProfile(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		report.Files = append(report.Files, files...)
+		AddPackageCoverage(report, files)
+	case FormatFunc:
+		functions, err := ParseFuncs(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		report.Functions = append(report.Functions, functions...)
+	default:
+		return fmt.Errorf("Unknown coverage format %q", format)
+	}
+
+	return nil
+}
+
+// Uploader attaches coverage to a build registered with a build service
+type Uploader struct {
+	Client    *http.Client
+	Server    string        // The base URL of the build service
+	Retries   int           // The number of times to retry a failed upload
+	RetryWait time.Duration // The wait before the first retry, doubled for each retry
+}
+
+// Upload PUTs the report to the build service, retrying on connection errors
+// and server errors
+func (u *Uploader) Upload(name, version string, report *models.CoverageReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("%s/builds/%s/%s/coverage", strings.TrimRight(u.Server, "/"), url.PathEscape(name), url.PathEscape(version))
+	wait := u.RetryWait
+
+	for attempt := 0; ; attempt++ {
+		retry, err := u.put(endpoint, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= u.Retries {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Upload failed, retrying in %v: %v\n", wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// put makes a single upload attempt, returning whether a failure is worth retrying
+func (u *Uploader) put(endpoint string, body []byte) (bool, error) {
+	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode >= 500, fmt.Errorf("Build service returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// UploadMain parses coverage from the files given in args (or stdin) and
+// attaches it to an existing build on a running build service
+func UploadMain(args []string) {
+	fs := flag.NewFlagSet("upload-coverage", flag.ExitOnError)
+	server := fs.String("server", defaultServer, "The URL of the build service")
+	name := fs.String("name", "", "The service name of the build")
+	version := fs.String("version", "", "The version of the build")
+	format := fs.String("format", FormatAuto, "The coverage format: auto, test, profile or func")
+	retries := fs.Int("retries", defaultRetries, "The number of times to retry a failed upload")
+	retryWait := fs.Duration("retrywait", defaultRetryWait, "The wait before the first retry")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: build-service upload-coverage -name NAME -version VERSION [flags] [file ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *name == "" || *version == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	report := new(models.CoverageReport)
+	if fs.NArg() == 0 {
+		if err := ParseReport(os.Stdin, *format, report); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		err = ParseReport(f, *format, report)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(1)
+		}
+	}
+
+	u := &Uploader{
+		Client:    &http.Client{Timeout: defaultUploadTimeout},
+		Server:    *server,
+		Retries:   *retries,
+		RetryWait: *retryWait,
+	}
+	if err := u.Upload(*name, *version, report); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+package coverage_parser
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestDetectFormat(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected string
+	}{
+		{testOutput, FormatTest},
+		{testProfile, FormatProfile},
+		{testFuncs, FormatFunc},
+		{"", FormatTest},
+	}
+
+	for i, tc := range testCases {
+		if format := detectFormat([]byte(tc.data)); format != tc.expected {
+			t.Errorf("Expected %v, got %v (%d)", tc.expected, format, i)
+		}
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	report := new(models.CoverageReport)
+	for _, data := range []string{testOutput, testProfile, testFuncs} {
+		if err := ParseReport(strings.NewReader(data), FormatAuto, report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(report.Coverage) != 2 {
+		t.Errorf("Expected 2 packages, got %d", len(report.Coverage))
+	}
+	expected := map[string]models.StatementCount{"main": {Covered: 3, Total: 5}, "validate": {Covered: 4, Total: 4}}
+	if !reflect.DeepEqual(report.Statements, expected) || report.Coverage["main"] != 60 {
+		t.Errorf("Expected the profile to replace the package coverage with %v, got %v %v", expected, report.Statements, report.Coverage)
+	}
+	if total := models.AggregateCoverage(report.Coverages()); total != 77.78 {
+		t.Errorf("Expected 77.78%% weighted by statements, got %v", total)
+	}
+	if len(report.Files) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(report.Files))
+	}
+	if len(report.Functions) != 3 {
+		t.Errorf("Expected 3 functions, got %d", len(report.Functions))
+	}
+}
+
+func TestUpload(t *testing.T) {
+	testCases := []struct {
+		statuses         []int
+		retries          int
+		expectedAttempts int
+		expectError      bool
+	}{
+		{[]int{http.StatusOK}, 3, 1, false},
+		{[]int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}, 3, 3, false},
+		{[]int{http.StatusInternalServerError, http.StatusInternalServerError}, 1, 2, true},
+		{[]int{http.StatusNotFound}, 3, 1, true},
+	}
+
+	for i, tc := range testCases {
+		attempts := 0
+		ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method != "PUT" || r.URL.Path != "/builds/com.test/123/coverage" {
+				t.Errorf("Unexpected request %s %s (%d)", r.Method, r.URL.Path, i)
+			}
+			report := new(models.CoverageReport)
+			if err := json.NewDecoder(r.Body).Decode(report); err != nil || report.Coverage["pkg"] != 50 {
+				t.Errorf("Unexpected body %+v, %v (%d)", report, err, i)
+			}
+			rw.WriteHeader(tc.statuses[attempts])
+			attempts++
+		}))
+
+		u := &Uploader{Client: http.DefaultClient, Server: ts.URL, Retries: tc.retries}
+		err := u.Upload("com.test", "123", &models.CoverageReport{Coverage: map[string]float64{"pkg": 50}})
+		ts.Close()
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+		}
+		if attempts != tc.expectedAttempts {
+			t.Errorf("Expected %d attempts, got %d (%d)", tc.expectedAttempts, attempts, i)
+		}
+	}
+}
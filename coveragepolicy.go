@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	policyModeWarn   = "warn"
+	policyModeReject = "reject"
+)
+
+// CoveragePolicy defines the coverage required of new builds of the services
+// with a name prefix, optionally only on one branch
+type CoveragePolicy struct {
+	Prefix          string             // The service name prefix the policy applies to
+	Branch          string             // The branch the policy applies to, or any branch if blank
+	Mode            string             // warn to accept failing builds, reject to refuse them
+	MinTotal        float64            // The minimum total coverage
+	MaxRegression   float64            // The maximum drop in total coverage since the previous build on the branch
+	PackageMinimums map[string]float64 // The minimum coverage of individual packages
+}
+
+// CoveragePolicies is a set of policies, of which the most specific matching
+// one is applied to a build
+type CoveragePolicies []*CoveragePolicy
+
+// loadCoveragePolicies reads a JSON list of policies from a file
+func loadCoveragePolicies(path string) (CoveragePolicies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policies := make(CoveragePolicies, 0)
+	if err := json.NewDecoder(f).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Error decoding coverage policies: %v", err)
+	}
+
+	for _, p := range policies {
+		switch p.Mode {
+		case "":
+			p.Mode = policyModeWarn
+		case policyModeWarn, policyModeReject:
+		default:
+			return nil, fmt.Errorf("Unknown coverage policy mode %q for %q", p.Mode, p.Prefix)
+		}
+	}
+
+	return policies, nil
+}
+
+// Find returns the policy for a build, preferring the longest prefix and then
+// a branch specific policy, or nil if none match
+func (cp CoveragePolicies) Find(name, branch string) *CoveragePolicy {
+	var found *CoveragePolicy
+	for _, p := range cp {
+		if !strings.HasPrefix(name, p.Prefix) || (p.Branch != "" && p.Branch != branch) {
+			continue
+		}
+		if found == nil || len(p.Prefix) > len(found.Prefix) ||
+			(len(p.Prefix) == len(found.Prefix) && found.Branch == "" && p.Branch != "") {
+			found = p
+		}
+	}
+	return found
+}
+
+// Evaluate checks the coverage of a build against the policy. previous is the
+// last build on the same branch, and may be nil.
+func (p *CoveragePolicy) Evaluate(b, previous *models.Build) *models.CoverageGate {
+	gate := &models.CoverageGate{
+		Policy: p.Prefix,
+		Mode:   p.Mode,
+	}
+
+	total := models.AggregateCoverage(b.Coverages())
+	if len(b.Coverage) == 0 && (p.MinTotal > 0 || len(p.PackageMinimums) > 0) {
+		gate.Violations = append(gate.Violations, "No coverage reported")
+	} else if total < p.MinTotal {
+		gate.Violations = append(gate.Violations, fmt.Sprintf("Total coverage %.2f%% is below the minimum of %.2f%%", total, p.MinTotal))
+	}
+
+	if p.MaxRegression > 0 && previous != nil && len(previous.Coverage) > 0 {
+		prevTotal := models.AggregateCoverage(previous.Coverages())
+		if drop := models.Round(prevTotal - total); drop > p.MaxRegression {
+			gate.Violations = append(gate.Violations, fmt.Sprintf("Total coverage dropped by %.2f%% since %s, the maximum is %.2f%%", drop, previous.Version, p.MaxRegression))
+		}
+	}
+
+	packages := make([]string, 0, len(p.PackageMinimums))
+	for pkg := range p.PackageMinimums {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	for _, pkg := range packages {
+		min := p.PackageMinimums[pkg]
+		perc, ok := b.Coverage[pkg]
+		if !ok {
+			if len(b.Coverage) > 0 {
+				gate.Violations = append(gate.Violations, fmt.Sprintf("No coverage reported for %s", pkg))
+			}
+			continue
+		}
+		if perc < min {
+			gate.Violations = append(gate.Violations, fmt.Sprintf("Coverage of %s %.2f%% is below the minimum of %.2f%%", pkg, perc, min))
+		}
+	}
+
+	gate.Passed = len(gate.Violations) == 0
+
+	return gate
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestLoadCoveragePolicies(t *testing.T) {
+	testCases := []struct {
+		data          string
+		expectedModes []string
+		expectError   bool
+	}{
+		{`[{"Prefix":"com.HailoOSS."},{"Prefix":"com.HailoOSS.kernel.","Mode":"reject"}]`, []string{"warn", "reject"}, false},
+		{`[{"Prefix":"com.HailoOSS.","Mode":"explode"}]`, nil, true},
+		{`not json`, nil, true},
+	}
+
+	for i, tc := range testCases {
+		f, err := ioutil.TempFile("", "policies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.data)
+		f.Close()
+
+		policies, err := loadCoveragePolicies(f.Name())
+		os.Remove(f.Name())
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+			continue
+		}
+		for j, p := range policies {
+			if p.Mode != tc.expectedModes[j] {
+				t.Errorf("Expected mode %v, got %v (%d)", tc.expectedModes[j], p.Mode, i)
+			}
+		}
+	}
+}
+
+func TestFindCoveragePolicy(t *testing.T) {
+	policies := CoveragePolicies{
+		{Prefix: "com.HailoOSS."},
+		{Prefix: "com.HailoOSS.kernel."},
+		{Prefix: "com.HailoOSS.kernel.", Branch: "master"},
+	}
+
+	testCases := []struct {
+		name     string
+		branch   string
+		expected *CoveragePolicy
+	}{
+		{"com.HailoOSS.service.foo", "master", policies[0]},
+		{"com.HailoOSS.kernel.build-service", "feature", policies[1]},
+		{"com.HailoOSS.kernel.build-service", "master", policies[2]},
+		{"com.other.foo", "master", nil},
+	}
+
+	for i, tc := range testCases {
+		if p := policies.Find(tc.name, tc.branch); p != tc.expected {
+			t.Errorf("Expected policy %+v, got %+v (%d)", tc.expected, p, i)
+		}
+	}
+}
+
+func TestEvaluateCoveragePolicy(t *testing.T) {
+	build := validBuild() // 17.81% total, dao 12.3%, domain 100%
+	previous := validBuild()
+	previous.Version = "20130627000000"
+	previous.Statements = map[string]models.StatementCount{
+		"dao":    {Covered: 44, Total: 300},
+		"domain": {Covered: 20, Total: 20},
+	} // 20.00% total
+
+	noCoverage := validBuild()
+	noCoverage.CoverageReport = models.CoverageReport{}
+
+	testCases := []struct {
+		policy     CoveragePolicy
+		build      *models.Build
+		previous   *models.Build
+		violations []string
+	}{
+		{CoveragePolicy{MinTotal: 10}, &build, nil, nil},
+		{CoveragePolicy{MinTotal: 20}, &build, nil, []string{"Total coverage 17.81% is below the minimum of 20.00%"}},
+		{CoveragePolicy{MinTotal: 20}, &noCoverage, nil, []string{"No coverage reported"}},
+		{CoveragePolicy{MaxRegression: 2}, &build, &previous, []string{"Total coverage dropped by 2.19% since 20130627000000, the maximum is 2.00%"}},
+		{CoveragePolicy{MaxRegression: 2.5}, &build, &previous, nil},
+		{CoveragePolicy{MaxRegression: 2}, &build, &noCoverage, nil},
+		{
+			CoveragePolicy{PackageMinimums: map[string]float64{"dao": 20, "domain": 90, "handler": 50}},
+			&build,
+			nil,
+			[]string{"Coverage of dao 12.30% is below the minimum of 20.00%", "No coverage reported for handler"},
+		},
+	}
+
+	for i, tc := range testCases {
+		gate := tc.policy.Evaluate(tc.build, tc.previous)
+		if !reflect.DeepEqual(gate.Violations, tc.violations) {
+			t.Errorf("Expected violations %q, got %q (%d)", tc.violations, gate.Violations, i)
+		}
+		if gate.Passed != (len(tc.violations) == 0) {
+			t.Errorf("Expected passed to be %v (%d)", len(tc.violations) == 0, i)
+		}
+	}
+}
+
+func TestCreateBuildCoverageGate(t *testing.T) {
+	defer func() { coveragePolicies = nil }()
+
+	testCases := []struct {
+		mode           string
+		minTotal       float64
+		expectedStatus int
+		expectedBuilds int
+	}{
+		{policyModeWarn, 10, http.StatusOK, 1},
+		{policyModeWarn, 50, http.StatusOK, 1},
+		{policyModeReject, 10, http.StatusOK, 1},
+		{policyModeReject, 50, http.StatusUnprocessableEntity, 0},
+	}
+
+	for i, tc := range testCases {
+		coveragePolicies = CoveragePolicies{{Prefix: "com.HailoOSS.", Mode: tc.mode, MinTotal: tc.minTotal}}
+
+		recorder := httptest.NewRecorder()
+		data, _ := json.Marshal(validBuild())
+		req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(data))
+
+		repo := newTestRepo()
+		buildRepo = repo
+
+		createBuildHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+			continue
+		}
+		if len(repo.builds) != tc.expectedBuilds {
+			t.Errorf("Expected %v builds, got %v (%d)", tc.expectedBuilds, len(repo.builds), i)
+			continue
+		}
+
+		gate := new(models.CoverageGate)
+		if err := json.NewDecoder(recorder.Body).Decode(gate); err != nil {
+			t.Errorf("Error decoding gate: %v (%d)", err, i)
+			continue
+		}
+		if gate.Passed != (tc.minTotal < 17.81) {
+			t.Errorf("Unexpected gate result %+v (%d)", gate, i)
+		}
+		if tc.expectedBuilds > 0 && !reflect.DeepEqual(repo.builds[0].CoverageGate, gate) {
+			t.Errorf("Expected gate %+v to be stored, got %+v (%d)", gate, repo.builds[0].CoverageGate, i)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	trendBucketBuild = "" // A snapshot for every build
+	trendBucketDay   = "day"
+	trendBucketWeek  = "week"
+
+	trendAggLast = "last" // The last build in each bucket
+	trendAggMean = "mean" // The mean coverage of the builds in each bucket
+	trendAggMin  = "min"  // The lowest coverage of the builds in each bucket
+)
+
+// filterPackages keeps only the coverage of the given packages in each
+// snapshot, recalculating the total. Snapshots with none of the packages are
+// dropped.
+func filterPackages(snapshots models.CoverageSnapshots, packages []string) models.CoverageSnapshots {
+	if len(packages) == 0 {
+		return snapshots
+	}
+
+	wanted := make(map[string]bool)
+	for _, pkg := range packages {
+		wanted[pkg] = true
+	}
+
+	filtered := make(models.CoverageSnapshots, 0, len(snapshots))
+	for _, s := range snapshots {
+		coverages := make([]models.Coverage, 0, len(packages))
+		for _, c := range s.Coverages {
+			if wanted[c.PackageName] {
+				coverages = append(coverages, c)
+			}
+		}
+		if len(coverages) == 0 {
+			continue
+		}
+
+		s.Coverages = coverages
+		s.TotalCoverage = models.AggregateCoverage(coverages)
+		filtered = append(filtered, s)
+	}
+
+	return filtered
+}
+
+// bucketStart returns the start of the day or week (beginning on Monday),
+// in UTC, containing the timestamp
+func bucketStart(ts int64, bucket string) int64 {
+	t := time.Unix(ts, 0).UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if bucket == trendBucketWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start.Unix()
+}
+
+// bucketSnapshots aggregates the snapshots of each branch within each day or
+// week. The snapshots must be sorted by timestamp.
+func bucketSnapshots(snapshots models.CoverageSnapshots, bucket, agg string) models.CoverageSnapshots {
+	if bucket == trendBucketBuild {
+		return snapshots
+	}
+
+	type bucketKey struct {
+		branch string
+		start  int64
+	}
+
+	keys := make([]bucketKey, 0)
+	buckets := make(map[bucketKey]models.CoverageSnapshots)
+	for _, s := range snapshots {
+		key := bucketKey{s.Branch, bucketStart(s.Timestamp, bucket)}
+		if _, ok := buckets[key]; !ok {
+			keys = append(keys, key)
+		}
+		buckets[key] = append(buckets[key], s)
+	}
+
+	bucketed := make(models.CoverageSnapshots, 0, len(keys))
+	for _, key := range keys {
+		s := aggregateSnapshots(buckets[key], agg)
+		s.Timestamp = key.start
+		bucketed = append(bucketed, s)
+	}
+	sort.Sort(bucketed)
+
+	return bucketed
+}
+
+// aggregateSnapshots combines snapshots into one. Statement counts are only
+// kept when taking the last snapshot, as they can't be meaningfully combined.
+func aggregateSnapshots(snapshots models.CoverageSnapshots, agg string) models.CoverageSnapshot {
+	last := snapshots[len(snapshots)-1]
+	if agg == trendAggLast {
+		last.Builds = len(snapshots)
+		return last
+	}
+
+	aggregate := func(values []float64) float64 {
+		result := values[0]
+		for _, v := range values[1:] {
+			switch agg {
+			case trendAggMin:
+				if v < result {
+					result = v
+				}
+			case trendAggMean:
+				result += v
+			}
+		}
+		if agg == trendAggMean {
+			result /= float64(len(values))
+		}
+		return models.Round(result)
+	}
+
+	totals := make([]float64, len(snapshots))
+	byPackage := make(map[string][]float64)
+	for i, s := range snapshots {
+		totals[i] = s.TotalCoverage
+		for _, c := range s.Coverages {
+			byPackage[c.PackageName] = append(byPackage[c.PackageName], c.Percentage)
+		}
+	}
+
+	packages := make([]string, 0, len(byPackage))
+	for pkg := range byPackage {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	coverages := make([]models.Coverage, len(packages))
+	for i, pkg := range packages {
+		coverages[i] = models.Coverage{
+			PackageName: pkg,
+			Percentage:  aggregate(byPackage[pkg]),
+		}
+	}
+
+	return models.CoverageSnapshot{
+		Coverages:     coverages,
+		TotalCoverage: aggregate(totals),
+		Branch:        last.Branch,
+		Version:       last.Version,
+		Builds:        len(snapshots),
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+// Timestamps on Tuesday 2013-06-25 and Wednesday 2013-06-26
+const (
+	tuesday   = 1372118400 + 3600
+	wednesday = 1372204800 + 3600
+)
+
+func testSnapshots() models.CoverageSnapshots {
+	return models.CoverageSnapshots{
+		{
+			Timestamp: tuesday, Branch: "master", Version: "1", TotalCoverage: 40,
+			Coverages: []models.Coverage{{PackageName: "dao", Percentage: 20}, {PackageName: "domain", Percentage: 60}},
+		},
+		{
+			Timestamp: tuesday + 60, Branch: "feature", Version: "2", TotalCoverage: 10,
+			Coverages: []models.Coverage{{PackageName: "dao", Percentage: 10}},
+		},
+		{
+			Timestamp: tuesday + 120, Branch: "master", Version: "3", TotalCoverage: 50,
+			Coverages: []models.Coverage{{PackageName: "dao", Percentage: 30}, {PackageName: "domain", Percentage: 70}},
+		},
+		{
+			Timestamp: wednesday, Branch: "master", Version: "4", TotalCoverage: 55,
+			Coverages: []models.Coverage{{PackageName: "dao", Percentage: 40}, {PackageName: "domain", Percentage: 70}},
+		},
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	monday := int64(1372032000)
+
+	testCases := []struct {
+		ts       int64
+		bucket   string
+		expected int64
+	}{
+		{tuesday, trendBucketDay, tuesday - 3600},
+		{wednesday, trendBucketDay, wednesday - 3600},
+		{tuesday, trendBucketWeek, monday},
+		{wednesday, trendBucketWeek, monday},
+		{monday, trendBucketWeek, monday},
+		{monday - 1, trendBucketWeek, monday - 7*24*3600},
+	}
+
+	for i, tc := range testCases {
+		if start := bucketStart(tc.ts, tc.bucket); start != tc.expected {
+			t.Errorf("Expected %v, got %v (%d)", tc.expected, start, i)
+		}
+	}
+}
+
+func TestFilterPackages(t *testing.T) {
+	filtered := filterPackages(testSnapshots(), []string{"domain"})
+
+	if len(filtered) != 3 {
+		t.Fatalf("Expected 3 snapshots, got %d", len(filtered))
+	}
+	for _, s := range filtered {
+		if len(s.Coverages) != 1 || s.Coverages[0].PackageName != "domain" || s.TotalCoverage != s.Coverages[0].Percentage {
+			t.Errorf("Unexpected snapshot %+v", s)
+		}
+	}
+}
+
+func TestBucketSnapshots(t *testing.T) {
+	testCases := []struct {
+		bucket   string
+		agg      string
+		expected models.CoverageSnapshots
+	}{
+		{trendBucketBuild, trendAggLast, testSnapshots()},
+		{
+			trendBucketDay, trendAggLast,
+			models.CoverageSnapshots{
+				{Timestamp: tuesday - 3600, Branch: "feature", Version: "2", TotalCoverage: 10, Builds: 1, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 10}}},
+				{Timestamp: tuesday - 3600, Branch: "master", Version: "3", TotalCoverage: 50, Builds: 2, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 30}, {PackageName: "domain", Percentage: 70}}},
+				{Timestamp: wednesday - 3600, Branch: "master", Version: "4", TotalCoverage: 55, Builds: 1, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 40}, {PackageName: "domain", Percentage: 70}}},
+			},
+		},
+		{
+			trendBucketWeek, trendAggMean,
+			models.CoverageSnapshots{
+				{Timestamp: 1372032000, Branch: "feature", Version: "2", TotalCoverage: 10, Builds: 1, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 10}}},
+				{Timestamp: 1372032000, Branch: "master", Version: "4", TotalCoverage: 48.33, Builds: 3, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 30}, {PackageName: "domain", Percentage: 66.67}}},
+			},
+		},
+		{
+			trendBucketWeek, trendAggMin,
+			models.CoverageSnapshots{
+				{Timestamp: 1372032000, Branch: "feature", Version: "2", TotalCoverage: 10, Builds: 1, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 10}}},
+				{Timestamp: 1372032000, Branch: "master", Version: "4", TotalCoverage: 40, Builds: 3, Coverages: []models.Coverage{{PackageName: "dao", Percentage: 20}, {PackageName: "domain", Percentage: 60}}},
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		actual := bucketSnapshots(testSnapshots(), tc.bucket, tc.agg)
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("Test case %d failed\nExpected: %+v\nActual:   %+v", i, tc.expected, actual)
+		}
+	}
+}
+
+func TestGetCoverageTrendInvalidQuery(t *testing.T) {
+	testCases := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/builds?:name=com.test&bucket=day&aggregate=mean&branch=master&packages=dao,domain", http.StatusOK},
+		{"/builds?:name=com.test&bucket=month", http.StatusBadRequest},
+		{"/builds?:name=com.test&bucket=day&aggregate=max", http.StatusBadRequest},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+
+		buildRepo = newTestRepo()
+		getCoverageTrendHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+		}
+	}
+}
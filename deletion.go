@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	defaultPurgeAfter    = 30 * 24 * time.Hour
+	defaultPurgeInterval = time.Hour
+	purgerActor          = "purger"
+)
+
+// errBuildExists is returned by a BuildRepository when creating a build with
+// the name and version of another, even a deleted one. Deleted builds are
+// restored rather than created again.
+var errBuildExists = errors.New("The build already exists")
+
+func restoreBuildHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("POST RESTORE", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	if serviceName == "" {
+		logHTTPError(rw, "Missing Service Name", http.StatusBadRequest)
+		return
+	}
+
+	if buildVersion == "" {
+		logHTTPError(rw, "Missing version", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(rw, r, actionDelete, serviceName) {
+		return
+	}
+
+	restored, err := buildRepo.Restore(serviceName, buildVersion, newAuditEvent(r, models.AuditRestore, serviceName, buildVersion))
+	if err != nil {
+		logHTTPError(rw, "Error restoring build", http.StatusInternalServerError)
+		return
+	}
+	if !restored {
+		logHTTPError(rw, "Deleted build not found", http.StatusNotFound)
+		return
+	}
+}
+
+// purgeDeletedBuilds permanently deletes the builds deleted more than
+// purgeAfter ago, along with their artifacts, returning how many there were
+func purgeDeletedBuilds(now time.Time, purgeAfter time.Duration) (int, error) {
+	audit := &models.AuditEvent{Time: now.Unix(), Actor: purgerActor, Action: models.AuditPurge}
+	purged, err := buildRepo.Purge(now.Add(-purgeAfter).Unix(), audit)
+	for _, b := range purged {
+		deleteArtifacts(b)
+	}
+	return len(purged), err
+}
+
+// runPurger purges deleted builds every interval
+func runPurger(purgeAfter, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		n, err := purgeDeletedBuilds(now, purgeAfter)
+		if err != nil {
+			log.Printf("Failed to purge deleted builds: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Purged %d deleted builds", n)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestSoftDelete(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+	build := validBuild()
+	repo.builds = append(repo.builds, &build)
+
+	path := "/builds/com.HailoOSS.kernel.build-service/20130627091746"
+	testCases := []struct {
+		method         string
+		path           string
+		expectedStatus int
+		expectedBuilds int
+	}{
+		{"DELETE", path + "?reason=" + strings.Repeat("x", 1025), http.StatusUnprocessableEntity, 1},
+		{"DELETE", path + "?reason=bad+binary", http.StatusOK, 0},
+		{"GET", "/builds?include_deleted=true", http.StatusOK, 1},
+		{"GET", "/builds/com.HailoOSS.kernel.build-service?include_deleted=true", http.StatusOK, 1},
+		{"PUT", path + "/coverage", http.StatusNotFound, 0},
+		{"POST", path + "/restore", http.StatusOK, 1},
+		{"POST", path + "/restore", http.StatusNotFound, 1},
+		{"POST", "/builds/com.HailoOSS.kernel.build-service/20130627091747/restore", http.StatusNotFound, 1},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(`{"Coverage":{"dao":50}}`))
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+
+		// Otherwise check the builds which are listed by default
+		if tc.method != "GET" {
+			req, _ = http.NewRequest("GET", "/builds", nil)
+			recorder = httptest.NewRecorder()
+			router().ServeHTTP(recorder, req)
+		}
+		var builds []*models.Build
+		json.NewDecoder(recorder.Body).Decode(&builds)
+		if len(builds) != tc.expectedBuilds {
+			t.Errorf("Expected %v builds, Got %v (%d)", tc.expectedBuilds, len(builds), i)
+		}
+	}
+
+	req, _ := http.NewRequest("DELETE", path+"?reason=bad+binary", nil)
+	router().ServeHTTP(httptest.NewRecorder(), req)
+	if d := repo.builds[0].Deleted; d == nil || d.Reason != "bad binary" || d.Actor != anonymousActor {
+		t.Fatalf("Expected the build to be deleted by %s, Got %+v", anonymousActor, d)
+	}
+
+	req, _ = http.NewRequest("GET", path, nil)
+	recorder := httptest.NewRecorder()
+	router().ServeHTTP(recorder, req)
+	if strings.TrimSpace(recorder.Body.String()) != "null" {
+		t.Errorf("Expected a deleted build to be hidden, Got %s", recorder.Body)
+	}
+}
+
+func TestRecreateBuild(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+	commitRepo = newTestCommitRepo()
+
+	build, _ := json.Marshal(validBuild())
+	path := "/builds/com.HailoOSS.kernel.build-service/20130627091746"
+	testCases := []struct {
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"POST", "/builds", string(build), http.StatusOK},
+		{"POST", "/builds", string(build), http.StatusConflict},
+		{"DELETE", path, "", http.StatusOK},
+		{"POST", "/builds", string(build), http.StatusConflict},
+		{"POST", path + "/restore", "", http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+
+	if len(repo.builds) != 1 || repo.builds[0].Deleted != nil {
+		t.Errorf("Expected one live build, Got %+v", repo.builds)
+	}
+}
+
+func TestPurgeDeletedBuilds(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+
+	now := time.Now()
+	old, recent, kept := validBuild(), validBuild(), validBuild()
+	old.Version, recent.Version, kept.Version = "20130627091741", "20130627091742", "20130627091743"
+	old.Deleted = &models.Deletion{Time: now.Add(-31 * 24 * time.Hour).Unix(), Actor: "ops"}
+	recent.Deleted = &models.Deletion{Time: now.Add(-time.Hour).Unix(), Actor: "ops"}
+	repo.builds = append(repo.builds, &old, &recent, &kept)
+
+	n, err := purgeDeletedBuilds(now, defaultPurgeAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(repo.builds) != 2 || repo.builds[0].Version != recent.Version {
+		t.Errorf("Expected only %s to be purged, Got %d purged", old.Version, n)
+	}
+
+	if len(repo.audit) != 1 || repo.audit[0].Action != models.AuditPurge || repo.audit[0].Version != old.Version || repo.audit[0].Actor != purgerActor {
+		t.Errorf("Expected the purge to be audited, Got %+v", repo.audit)
+	}
+}
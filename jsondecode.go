@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/HailoOSS/build-service/validate"
+)
+
+const defaultMaxBodySize = 10 << 20 // Cover profiles of large services can run to megabytes
+
+// decodeJSON decodes a request body into v, refusing bodies larger than
+// maxBodySize and, when strictJSON is set, fields which don't exactly match a
+// field of v. Failures are returned as a problem document.
+func decodeJSON(body io.Reader, v interface{}) *problem {
+	data, p := readBody(body)
+	if p != nil {
+		return p
+	}
+	return unmarshalJSON(data, v)
+}
+
+// readBody reads a request body, refusing bodies larger than maxBodySize
+func readBody(body io.Reader) ([]byte, *problem) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, &problem{Title: "Error reading body", Status: http.StatusBadRequest, Detail: err.Error()}
+	}
+	if int64(len(data)) > maxBodySize {
+		return nil, &problem{
+			Title:  "Request body too large",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("The body must be at most %d bytes", maxBodySize),
+		}
+	}
+	return data, nil
+}
+
+// unmarshalJSON decodes a body which has already been read, like decodeJSON
+func unmarshalJSON(data []byte, v interface{}) *problem {
+	if err := json.Unmarshal(data, v); err != nil {
+		p := &problem{Title: "Invalid JSON", Status: http.StatusBadRequest, Detail: err.Error()}
+		switch e := err.(type) {
+		case *json.SyntaxError:
+			p.Detail = fmt.Sprintf("Syntax error at byte %d: %v", e.Offset, e)
+		case *json.UnmarshalTypeError:
+			field := e.Field
+			if field == "" {
+				field = "Body"
+			}
+			p.Detail = ""
+			p.Errors = []*validate.FieldError{{
+				Field:   field,
+				Path:    field,
+				Rule:    "type",
+				Message: fmt.Sprintf("%s must be %s, not %s", field, describeType(e.Type), e.Value),
+			}}
+		}
+		return p
+	}
+
+	if strictJSON {
+		t := reflect.TypeOf(v)
+		if errors := unknownFields(data, t, ""); len(errors) > 0 {
+			return &problem{Title: "Unknown fields", Status: http.StatusBadRequest, Errors: errors}
+		}
+	}
+
+	return nil
+}
+
+// describeType returns the JSON description of a Go type for error messages
+func describeType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// unknownFields returns an error for each object key in data, which has
+// already been decoded into t, that doesn't exactly match a field. Keys which
+// only differ in case are reported, as encoding/json accepts them silently.
+func unknownFields(data []byte, t reflect.Type, path string) []*validate.FieldError {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	errors := make([]*validate.FieldError, 0)
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if reflect.PtrTo(t).Implements(reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()) {
+			break
+		}
+
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			break
+		}
+		fields := jsonFields(t)
+
+		for _, key := range sortedKeys(obj) {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+
+			if ft, ok := fields[key]; ok {
+				errors = append(errors, unknownFields(obj[key], ft, fieldPath)...)
+				continue
+			}
+
+			msg := fmt.Sprintf("%s is not a known field", fieldPath)
+			for name := range fields {
+				if strings.EqualFold(name, key) {
+					msg = fmt.Sprintf("%s is not a known field, did you mean %s?", fieldPath, name)
+					break
+				}
+			}
+			errors = append(errors, &validate.FieldError{Field: fieldPath, Path: fieldPath, Rule: "unknown", Message: msg})
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			break
+		}
+		for _, key := range sortedKeys(obj) {
+			errors = append(errors, unknownFields(obj[key], t.Elem(), fmt.Sprintf("%s[%q]", path, key))...)
+		}
+	case reflect.Slice, reflect.Array:
+		var arr []json.RawMessage
+		if err := json.Unmarshal(data, &arr); err != nil {
+			break
+		}
+		for i, elem := range arr {
+			errors = append(errors, unknownFields(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errors
+}
+
+// jsonFields returns the types of the fields of a struct by their JSON names,
+// including the fields of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name := tag
+		if j := strings.Index(tag, ","); j != -1 {
+			name = tag[:j]
+		}
+
+		if name == "-" && !strings.HasPrefix(tag, "-,") {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for embeddedName, ft := range jsonFields(f.Type) {
+				if _, ok := fields[embeddedName]; !ok {
+					fields[embeddedName] = ft
+				}
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func sortedKeys(obj map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	defer func(strict bool, max int64) { strictJSON, maxBodySize = strict, max }(strictJSON, maxBodySize)
+	maxBodySize = 100
+
+	testCases := []struct {
+		body             string
+		strict           bool
+		expectedStatus   int
+		expectedMessages []string
+	}{
+		{`{"Name":"a","Coverage":{"dao":1}}`, true, 0, nil},
+		{`{"SourceUrl":"a","Shiny":true}`, false, 0, nil},
+		{`{"SourceUrl":"a","Shiny":true}`, true, http.StatusBadRequest, []string{
+			"Shiny is not a known field",
+			"SourceUrl is not a known field, did you mean SourceURL?",
+		}},
+		{`{"Files":[{"FileName":"a.go","Lines":1}]}`, true, http.StatusBadRequest, []string{"Files[0].Lines is not a known field"}},
+		{`{"Version":20130627091746}`, false, http.StatusBadRequest, []string{"Version must be a string, not number"}},
+		{`{"Name":`, false, http.StatusBadRequest, nil},
+		{`{"Name":"` + strings.Repeat("a", 100) + `"}`, false, http.StatusRequestEntityTooLarge, nil},
+	}
+
+	for i, tc := range testCases {
+		strictJSON = tc.strict
+
+		p := decodeJSON(strings.NewReader(tc.body), new(models.Build))
+		if p == nil {
+			if tc.expectedStatus != 0 {
+				t.Errorf("Expected %v, Got no problem (%d)", tc.expectedStatus, i)
+			}
+			continue
+		}
+		if p.Status != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v %+v (%d)", tc.expectedStatus, p.Status, p, i)
+			continue
+		}
+		if len(p.Errors) != len(tc.expectedMessages) {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedMessages, p.Errors, i)
+			continue
+		}
+		for j, err := range p.Errors {
+			if err.Message != tc.expectedMessages[j] {
+				t.Errorf("Expected %q, Got %q (%d)", tc.expectedMessages[j], err.Message, i)
+			}
+		}
+	}
+}
+
+func TestCreateBuildDefaultTimeStamp(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	body := `{"Hostname":"localhost","Architecture":"amd64","GoVersion":"1.1.1",` +
+		`"SourceURL":"https://github.com/HailoOSS/build-service","BinaryURL":"http://s3.amazon.com/abcdefg",` +
+		`"BinarySHA256":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","BinarySize":4,` +
+		`"Version":"20130627091746","Language":"Go","Name":"com.HailoOSS.kernel.build-service","Branch":"master"}`
+	req, _ := http.NewRequest("POST", "/builds", bytes.NewReader([]byte(body)))
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	createBuildHandler(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v, Got %v: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	if len(repo.builds) != 1 || repo.builds[0].TimeStamp == 0 {
+		t.Errorf("Expected the build to have a timestamp, Got %+v", repo.builds)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const defaultLeaderboardDays = 30
+
+type byCoverage []models.ServiceCoverage
+
+func (s byCoverage) Len() int      { return len(s) }
+func (s byCoverage) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCoverage) Less(i, j int) bool {
+	if s[i].TotalCoverage != s[j].TotalCoverage {
+		return s[i].TotalCoverage > s[j].TotalCoverage
+	}
+	return s[i].Name < s[j].Name
+}
+
+// serviceCoverage summarises the coverage of the latest build of a service,
+// and how it has changed since the previous build, which is the latest from
+// before the period of the summary, if there is one
+func serviceCoverage(latest, previous *models.Build) models.ServiceCoverage {
+	coverages := latest.Coverages()
+	sc := models.ServiceCoverage{
+		Name:          latest.Name,
+		Version:       latest.Version,
+		Branch:        latest.Branch,
+		TotalCoverage: models.AggregateCoverage(coverages),
+		Packages:      len(coverages),
+	}
+
+	for _, c := range coverages {
+		if c.Percentage == 0 {
+			sc.UntestedPackages++
+		}
+	}
+
+	if previous != nil && len(previous.Coverage) > 0 {
+		sc.Change = models.Round(sc.TotalCoverage - models.AggregateCoverage(previous.Coverages()))
+	}
+
+	return sc
+}
+
+// rankServices sorts services by coverage, highest first, giving services
+// with equal coverage the same rank
+func rankServices(services []models.ServiceCoverage) {
+	sort.Sort(byCoverage(services))
+	for i := range services {
+		if i > 0 && services[i].TotalCoverage == services[i-1].TotalCoverage {
+			services[i].Rank = services[i-1].Rank
+		} else {
+			services[i].Rank = i + 1
+		}
+	}
+}
+
+func getCoverageLeaderboardHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET COVERAGE_LEADERBOARD", r.URL)
+
+	filter := r.URL.Query().Get("filter")
+	branch := r.URL.Query().Get("branch")
+
+	days := defaultLeaderboardDays
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 {
+		days = d
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -days)
+
+	names, err := buildRepo.GetNamesWithPrefix(filter)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting names: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// The latest builds of every service on each branch, now and before the
+	// period, fetched at once rather than for each service
+	latestBuilds, err := buildRepo.GetLatestBuilds(filter, branch, 0)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting latest builds: %v", err), http.StatusInternalServerError)
+		return
+	}
+	previousBuilds, err := buildRepo.GetLatestBuilds(filter, branch, since.Unix())
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting previous builds: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	latestByName := make(map[string]*models.Build)
+	for _, b := range latestBuilds {
+		if l := latestByName[b.Name]; l == nil || b.TimeStamp > l.TimeStamp {
+			latestByName[b.Name] = b
+		}
+	}
+	previousByBranch := make(map[[2]string]*models.Build) // Name, branch => build
+	for _, b := range previousBuilds {
+		previousByBranch[[2]string{b.Name, b.Branch}] = b
+	}
+
+	summary := &models.CoverageSummary{
+		Since:                   since.Unix(),
+		ServicesWithoutCoverage: make([]string, 0),
+		Services:                make([]models.ServiceCoverage, 0, len(names)),
+	}
+	allCoverages := make([]models.Coverage, 0)
+
+	for _, name := range names {
+		latest := latestByName[name]
+		if latest == nil || len(latest.Coverage) == 0 {
+			summary.ServicesWithoutCoverage = append(summary.ServicesWithoutCoverage, name)
+			continue
+		}
+
+		sc := serviceCoverage(latest, previousByBranch[[2]string{name, latest.Branch}])
+		summary.UntestedPackages += sc.UntestedPackages
+		summary.Services = append(summary.Services, sc)
+		allCoverages = append(allCoverages, latest.Coverages()...)
+	}
+
+	rankServices(summary.Services)
+	summary.TotalCoverage = models.AggregateCoverage(allCoverages)
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(summary)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestRankServices(t *testing.T) {
+	services := []models.ServiceCoverage{
+		{Name: "c", TotalCoverage: 50},
+		{Name: "b", TotalCoverage: 80},
+		{Name: "a", TotalCoverage: 50},
+		{Name: "d", TotalCoverage: 10},
+	}
+	rankServices(services)
+
+	expected := []models.ServiceCoverage{
+		{Rank: 1, Name: "b", TotalCoverage: 80},
+		{Rank: 2, Name: "a", TotalCoverage: 50},
+		{Rank: 2, Name: "c", TotalCoverage: 50},
+		{Rank: 4, Name: "d", TotalCoverage: 10},
+	}
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("\nExpected:%+v\nGot     :%+v", expected, services)
+	}
+}
+
+func TestGetCoverageLeaderboard(t *testing.T) {
+	now := time.Now().Unix()
+
+	old := validBuild()
+	old.Version = "1"
+	old.TimeStamp = now - 40*24*3600
+	old.Statements = nil
+	old.Coverage = map[string]float64{"dao": 40, "domain": 60}
+
+	// The change is measured from the latest build before the period, not
+	// the first one in it
+	recent := validBuild()
+	recent.Version = "3"
+	recent.TimeStamp = now - 10*24*3600
+	recent.Statements = nil
+	recent.Coverage = map[string]float64{"dao": 10}
+
+	latest := validBuild()
+	latest.Version = "2"
+	latest.TimeStamp = now - 3600
+	latest.Statements = nil
+	latest.Coverage = map[string]float64{"dao": 60, "domain": 80, "handler": 0}
+
+	other := validBuild()
+	other.Name = "com.HailoOSS.service.foo"
+	other.TimeStamp = now - 3600
+	other.Statements = nil
+	other.Coverage = map[string]float64{"dao": 90}
+
+	untested := validBuild()
+	untested.Name = "com.HailoOSS.service.bar"
+	untested.CoverageReport = models.CoverageReport{}
+
+	unmatched := validBuild()
+	unmatched.Name = "org.example.com.HailoOSS.service"
+
+	repo := newTestRepo()
+	repo.builds = append(repo.builds, &old, &recent, &latest, &other, &untested, &unmatched)
+	buildRepo = repo
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/coverage/leaderboard?filter=com.HailoOSS.", nil)
+	getCoverageLeaderboardHandler(recorder, req)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v, Got %v", http.StatusOK, recorder.Code)
+	}
+
+	summary := new(models.CoverageSummary)
+	if err := json.NewDecoder(recorder.Body).Decode(summary); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []models.ServiceCoverage{
+		{Rank: 1, Name: "com.HailoOSS.service.foo", Version: "20130627091746", Branch: "master", TotalCoverage: 90, Packages: 1},
+		{Rank: 2, Name: "com.HailoOSS.kernel.build-service", Version: "2", Branch: "master", TotalCoverage: 46.67, Change: -3.33, Packages: 3, UntestedPackages: 1},
+	}
+	if !reflect.DeepEqual(summary.Services, expected) {
+		t.Errorf("\nExpected:%+v\nGot     :%+v", expected, summary.Services)
+	}
+	if !reflect.DeepEqual(summary.ServicesWithoutCoverage, []string{"com.HailoOSS.service.bar"}) {
+		t.Errorf("Unexpected services without coverage: %v", summary.ServicesWithoutCoverage)
+	}
+	if summary.UntestedPackages != 1 || summary.TotalCoverage != 57.5 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+}
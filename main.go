@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bytes"
-	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/pat"
@@ -33,38 +32,86 @@ const (
 	envGithubToken = "BUILD_SERVICE_GITHUB_TOKEN"
 
 	defaultLimit                 = 10
+	defaultDiffBranch            = "master"
+	diffAgainstLatest            = "latest"
 	defaultCoverageTrendDuration = -90 * 24 * time.Hour // 90 days
 
 	defaultReadTimeout  = 30 * time.Second
 	defaultWriteTimeout = 30 * time.Second
 	defaultPort         = 3000
 	defaultTlsAddr      = ":8443"
-	defaultKey          = ""
-	defaultCert         = ""
 )
 
 var (
-	buildRepo     BuildRepository
-	commitRepo    CommitRepo
-	createTables  bool
-	listenPort    int
-	outputName    bool
-	outputVersion bool
-	runCoverage   bool
-	tlsListAddr   string
+	buildRepo            BuildRepository
+	commitRepo           CommitRepo
+	coveragePolicies     CoveragePolicies
+	clientPolicies       ClientPolicies
+	validationPolicies   ValidationPolicies
+	retentionPolicies    RetentionPolicies
+	createTables         bool
+	policyFile           string
+	validationPolicyFile string
+	retentionPolicyFile  string
+	listenPort           int
+	maxBodySize          int64
+	strictJSON           bool
+	outputName           bool
+	outputVersion        bool
+	runCoverage          bool
+	tlsListAddr          string
+	tlsCertFile          string
+	tlsKeyFile           string
+	tlsClientCAFile      string
+	clientPolicyFile     string
+	disableHTTP          bool
+	disableTLS           bool
+	requireTokens        bool
+	publicBadges         bool
+	corsOrigins          string
+	publisherSecretFile  string
+	publisherSecrets     map[string]string
+	signatureMaxAge      time.Duration
+	purgeAfter           time.Duration
+	artifactDir          string
+	artifactBaseURL      string
+	artifactStore        BlobStore
+	maxArtifactSize      int64
+	verifyInterval       time.Duration
+	verifyTimeout        time.Duration
 )
 
 // BuildRepository defines the interface required by a build data store
 type BuildRepository interface {
-	Create(b *models.Build) error
-	GetAll(limit int) ([]*models.Build, error)
-	GetAllWithName(name string, limit int) ([]*models.Build, error)
+	Create(b *models.Build, audit *models.AuditEvent) error
+	GetAll(status string, includeDeleted bool, limit int) ([]*models.Build, error)
+	GetAllWithName(name, branch, status string, includeDeleted bool, limit int) ([]*models.Build, error)
+	GetOlderWithName(name string, timestamp int64, version string, limit int) ([]*models.Build, error)
 	GetVersion(name, version string) (*models.Build, error)
-	Delete(name, version string) error
+	GetLatest(name, branch string) (*models.Build, error)
+	GetLatestBefore(name, branch string, before int64) (*models.Build, error)
+	GetLatestBuilds(prefix, branch string, before int64) ([]*models.Build, error)
+	SetStatus(b *models.Build, previous string, audit *models.AuditEvent) error
+	SetBinary(name, version, binaryURL, sha256 string, size int64, audit *models.AuditEvent) error
+	SetArtifact(name, version string, a *models.Artifact, audit *models.AuditEvent) error
+	SetVerification(name, version string, v *models.Verification) error
+	CreateToken(t *models.APIToken) error
+	GetToken(hash string) (*models.APIToken, error)
+	GetTokens() ([]*models.APIToken, error)
+	RevokeToken(id string, at int64) (bool, error)
+	Delete(name, version string, d *models.Deletion, audit *models.AuditEvent) error
+	Restore(name, version string, audit *models.AuditEvent) (bool, error)
+	Purge(deletedBefore int64, audit *models.AuditEvent) ([]*models.Build, error)
+	Prune(name, version string, audit *models.AuditEvent) (*models.Build, error)
 	GetNames(filter string) ([]string, error)
+	GetNamesWithPrefix(prefix string) ([]string, error)
 	GetCoverage(name, version string) (map[string]float64, error)
-	GetCoverageTrend(name string, since time.Time) (models.CoverageSnapshots, error)
+	SetCoverage(name, version string, c *models.CoverageReport, audit *models.AuditEvent) error
+	GetFileCoverage(name, version string, limit int) ([]models.FileCoverage, error)
+	GetFunctionCoverage(name, version string, limit int) ([]models.FunctionCoverage, error)
+	GetCoverageTrend(name, branch string, since, until time.Time) (models.CoverageSnapshots, error)
 	SetMergeBaseDate(name, version, importPath, commit string, date time.Time) error
+	GetAuditEvents(f *models.AuditFilter) ([]*models.AuditEvent, error)
 }
 
 type CommitRepo interface {
@@ -76,6 +123,26 @@ func logHTTPError(rw http.ResponseWriter, err string, status int) {
 	log.Println(err)
 }
 
+// problem is an RFC 7807 problem document
+type problem struct {
+	Type   string                 `json:"type,omitempty"`
+	Title  string                 `json:"title"`
+	Status int                    `json:"status"`
+	Detail string                 `json:"detail,omitempty"`
+	Errors []*validate.FieldError `json:"errors,omitempty"`
+}
+
+func logProblem(rw http.ResponseWriter, p *problem) {
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(p.Status)
+	json.NewEncoder(rw).Encode(p)
+
+	log.Println(p.Title, p.Detail)
+	for _, e := range p.Errors {
+		log.Println(p.Title+":", e.Message)
+	}
+}
+
 func getNamesHandler(rw http.ResponseWriter, r *http.Request) {
 	log.Println("GET", r.URL)
 
@@ -100,25 +167,106 @@ func createBuildHandler(rw http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	body, p := readBody(r.Body)
+	if p != nil {
+		logProblem(rw, p)
+		return
+	}
+
+	var publisher, signature string
+	if publisherSecrets != nil {
+		var err error
+		signature, err = verifySignature(r, body, publisherSecrets, signatureMaxAge, time.Now())
+		if err != nil {
+			logProblem(rw, &problem{Title: "Invalid signature", Status: http.StatusUnauthorized, Detail: err.Error()})
+			return
+		}
+		publisher = r.Header.Get(headerPublisher)
+
+		// The signature is only spent once the build is saved, so that a
+		// rejected build can be sent again
+		defer func() {
+			if signature != "" {
+				signatureReplays.remove(signature)
+			}
+		}()
+	}
+
 	build := new(models.Build)
+	if p := unmarshalJSON(body, build); p != nil {
+		logProblem(rw, p)
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(build)
-	if err != nil {
-		logHTTPError(rw, "Error decoding JSON", http.StatusBadRequest)
+	if !authorize(rw, r, actionWrite, build.Name) {
 		return
 	}
 
-	errors := validate.Validate(build)
+	build.Deleted = nil
+	if build.TimeStamp == 0 {
+		build.TimeStamp = time.Now().Unix()
+	}
+	if build.Status == "" {
+		build.Status = models.StatusSucceeded
+	}
+	if build.Status == models.StatusRunning && build.StartedAt == 0 {
+		build.StartedAt = time.Now().Unix()
+	}
+
+	errors := ValidateBuild(build)
 	if len(errors) > 0 {
-		logHTTPError(rw, "Invalid build", http.StatusBadRequest)
+		logProblem(rw, &problem{
+			Title:  "Invalid build",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
 		return
 	}
 
-	err = buildRepo.Create(build)
+	if err := parseCoverProfile(&build.CoverageReport); err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error parsing cover profile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	build.CoverageGate = nil
+	if policy := coveragePolicies.Find(build.Name, build.Branch); policy != nil {
+		previous, err := buildRepo.GetLatest(build.Name, build.Branch)
+		if err != nil {
+			logHTTPError(rw, fmt.Sprintf("Error getting previous build: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		build.CoverageGate = policy.Evaluate(build, previous)
+		if !build.CoverageGate.Passed {
+			log.Printf("Coverage gate failed for %s %s (%s): %v", build.Name, build.Version, build.CoverageGate.Mode, build.CoverageGate.Violations)
+		}
+		if !build.CoverageGate.Passed && build.CoverageGate.Mode == policyModeReject {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(rw).Encode(build.CoverageGate)
+			return
+		}
+	}
+
+	audit := newAuditEvent(r, models.AuditCreate, build.Name, build.Version)
+	if publisher != "" && audit.Actor == anonymousActor {
+		audit.Actor = publisherActor(publisher)
+	}
+	err := buildRepo.Create(build, audit)
+	if err == errBuildExists {
+		logHTTPError(rw, fmt.Sprintf("Build %s %s already exists, or has been deleted and can be restored", build.Name, build.Version), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		logHTTPError(rw, fmt.Sprintf("Error saving build: %v", err), http.StatusInternalServerError)
 		return
 	}
+	signature = ""
+
+	if build.CoverageGate != nil {
+		rw.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(rw).Encode(build.CoverageGate)
+	}
 
 	go func() {
 		for importPath, commit := range build.Dependencies {
@@ -130,12 +278,32 @@ func createBuildHandler(rw http.ResponseWriter, r *http.Request) {
 
 			err = buildRepo.SetMergeBaseDate(build.Name, build.Version, importPath, commit, *mergeBaseDate)
 			if err != nil {
-				log.Printf("Failed to set commit date (%s, %s, %s, %s): %s", build.Name, build.Version, importPath, commit)
+				log.Printf("Failed to set commit date (%s, %s, %s, %s): %s", build.Name, build.Version, importPath, commit, err)
 			}
 		}
 	}()
 }
 
+// parseCoverProfile replaces the raw cover profile of a report with the per
+// file coverage it contains
+func parseCoverProfile(c *models.CoverageReport) error {
+	if c.CoverProfile == "" {
+		return nil
+	}
+
+	files, err := coverage_parser.ParseProfile(strings.NewReader(c.CoverProfile))
+	if err != nil {
+		return err
+	}
+	c.Files = files
+	c.CoverProfile = ""
+	if len(c.Statements) == 0 {
+		coverage_parser.AddPackageCoverage(c, files)
+	}
+
+	return nil
+}
+
 func deleteBuildHandler(rw http.ResponseWriter, r *http.Request) {
 	log.Println("DELETE", r.URL)
 
@@ -152,34 +320,60 @@ func deleteBuildHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := buildRepo.Delete(serviceName, buildVersion)
+	if !authorize(rw, r, actionDelete, serviceName) {
+		return
+	}
+
+	audit := newAuditEvent(r, models.AuditDelete, serviceName, buildVersion)
+	deletion := &models.Deletion{Time: audit.Time, Actor: audit.Actor, Reason: r.URL.Query().Get("reason")}
+	if errors := validate.Validate(deletion); len(errors) > 0 {
+		logProblem(rw, &problem{
+			Title:  "Invalid deletion",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
+		return
+	}
+
+	err := buildRepo.Delete(serviceName, buildVersion, deletion, audit)
 	if err != nil {
 		logHTTPError(rw, "Error deleting build", http.StatusInternalServerError)
 		return
 	}
 }
 
+// queryLimit returns the limit query parameter, or the default if it's not set
+func queryLimit(r *http.Request) int {
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		return l
+	}
+	return defaultLimit
+}
+
 func getBuildsHandler(rw http.ResponseWriter, r *http.Request) {
 	log.Println("GET BUILDS", r.URL)
 
 	serviceName := r.URL.Query().Get(":name")
 	buildVersion := r.URL.Query().Get(":version")
 
-	limitQuery := r.URL.Query().Get("limit")
-	limit := defaultLimit
-	if l, err := strconv.Atoi(limitQuery); err == nil {
-		limit = l
-	}
+	limit := queryLimit(r)
+	includeDeleted := r.URL.Query().Get("include_deleted") == "true"
 
 	// We're getting a list of builds
 	if buildVersion == "" {
 		var builds []*models.Build
 		var err error
 
+		status := r.URL.Query().Get("status")
+		if status != "" && !validStatus(status) {
+			logHTTPError(rw, fmt.Sprintf("Unknown status %q", status), http.StatusBadRequest)
+			return
+		}
+
 		if serviceName == "" {
-			builds, err = buildRepo.GetAll(limit)
+			builds, err = buildRepo.GetAll(status, includeDeleted, limit)
 		} else {
-			builds, err = buildRepo.GetAllWithName(serviceName, limit)
+			builds, err = buildRepo.GetAllWithName(serviceName, "", status, includeDeleted, limit)
 		}
 
 		if err != nil {
@@ -203,6 +397,9 @@ func getBuildsHandler(rw http.ResponseWriter, r *http.Request) {
 		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if build != nil && build.Deleted != nil && !includeDeleted {
+		build = nil
+	}
 
 	rw.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(rw).Encode(build)
@@ -226,54 +423,269 @@ func getCoverageHandler(rw http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(rw).Encode(coverage)
 }
 
+func setCoverageHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("PUT COVERAGE", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	if serviceName == "" {
+		logHTTPError(rw, "Missing Service Name", http.StatusBadRequest)
+		return
+	}
+
+	if buildVersion == "" {
+		logHTTPError(rw, "Missing version", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(rw, r, actionWrite, serviceName) {
+		return
+	}
+
+	if r.Body == nil {
+		logHTTPError(rw, "No PUT body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	report := new(models.CoverageReport)
+	if p := decodeJSON(r.Body, report); p != nil {
+		logProblem(rw, p)
+		return
+	}
+
+	if errors := validate.Validate(report); len(errors) > 0 {
+		logProblem(rw, &problem{
+			Title:  "Invalid coverage",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
+		return
+	}
+
+	if err := parseCoverProfile(report); err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error parsing cover profile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	audit := newAuditEvent(r, models.AuditCoverage, serviceName, buildVersion)
+	if err := buildRepo.SetCoverage(serviceName, buildVersion, report, audit); err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error saving code coverage: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func getCoverageDiffHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET COVERAGE_DIFF", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	// Compare against a specific version, or the latest build on a branch
+	// before this one
+	var against *models.Build
+	againstVersion := r.URL.Query().Get("against")
+	if againstVersion == "" || againstVersion == diffAgainstLatest {
+		branch := r.URL.Query().Get("branch")
+		if branch == "" {
+			branch = defaultDiffBranch
+		}
+		against, err = buildRepo.GetLatestBefore(serviceName, branch, build.TimeStamp)
+	} else {
+		against, err = buildRepo.GetVersion(serviceName, againstVersion)
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build to compare against: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if against == nil {
+		logHTTPError(rw, "Build to compare against not found", http.StatusNotFound)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(models.DiffCoverage(build, against))
+}
+
+func getFileCoverageHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET FILE_COVERAGE", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	files, err := buildRepo.GetFileCoverage(serviceName, buildVersion, queryLimit(r))
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting file coverage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(files)
+}
+
+func getFunctionCoverageHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET FUNCTION_COVERAGE", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	functions, err := buildRepo.GetFunctionCoverage(serviceName, buildVersion, queryLimit(r))
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting function coverage: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(functions)
+}
+
 func getCoverageTrendHandler(rw http.ResponseWriter, r *http.Request) {
 	log.Println("GET COVERAGE_TREND", r.URL)
 
-	serviceName := r.URL.Query().Get(":name")
-	since, err := time.Parse("20060102150405", r.URL.Query().Get("since"))
+	query := r.URL.Query()
+	serviceName := query.Get(":name")
+	branch := query.Get("branch")
+
+	since, err := time.Parse("20060102150405", query.Get("since"))
 	if err != nil {
 		since = time.Now().Add(defaultCoverageTrendDuration) // default to 90 days ago
 	}
+	until, err := time.Parse("20060102150405", query.Get("until"))
+	if err != nil {
+		until = time.Now()
+	}
 
-	trend, err := buildRepo.GetCoverageTrend(serviceName, since)
+	bucket := query.Get("bucket")
+	if bucket != trendBucketBuild && bucket != trendBucketDay && bucket != trendBucketWeek {
+		logHTTPError(rw, fmt.Sprintf("Unknown bucket %q, expected day or week", bucket), http.StatusBadRequest)
+		return
+	}
+	agg := query.Get("aggregate")
+	if agg == "" {
+		agg = trendAggLast
+	}
+	if agg != trendAggLast && agg != trendAggMean && agg != trendAggMin {
+		logHTTPError(rw, fmt.Sprintf("Unknown aggregate %q, expected last, mean or min", agg), http.StatusBadRequest)
+		return
+	}
+
+	var packages []string
+	if p := query.Get("packages"); p != "" {
+		packages = strings.Split(p, ",")
+	}
+
+	trend, err := buildRepo.GetCoverageTrend(serviceName, branch, since, until)
 	if err != nil {
 		logHTTPError(rw, fmt.Sprintf("Error getting code coverage trend: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	trend = bucketSnapshots(filterPackages(trend, packages), bucket, agg)
+
 	rw.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(rw).Encode(trend)
 }
 
-// ValidateBuild checks that a build is valid
-func ValidateBuild(build *models.Build) []error {
-	return validate.Validate(build)
+// ValidateBuild checks that a build is valid, including against any
+// validation policies for the service
+func ValidateBuild(build *models.Build) []*validate.FieldError {
+	return validationPolicies.Validate(build)
 }
 
+// allowRemoteHandler allows cross-origin requests from the origins, or from
+// anywhere if they include *, and answers preflight requests itself
 type allowRemoteHandler struct {
-	h http.Handler
+	h       http.Handler
+	origins []string
 }
 
 func (arh allowRemoteHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+	allowed := true
+	if contains(arh.origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if origin := req.Header.Get("Origin"); origin != "" && contains(arh.origins, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	} else {
+		allowed = false
+	}
+
+	if req.Method == "OPTIONS" {
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	arh.h.ServeHTTP(w, req)
 }
 
 func router() http.Handler {
 	r := pat.New()
 
+	r.Post("/tokens", createTokenHandler)
+	r.Get("/tokens", getTokensHandler)
+	r.Delete("/tokens/{id}", revokeTokenHandler)
+
+	r.Get("/audit", getAuditEventsHandler)
+
+	r.Post("/builds/{name}/{version}/restore", restoreBuildHandler)
 	r.Post("/builds", createBuildHandler)
 
+	r.Put("/builds/{name}/{version}/coverage", setCoverageHandler)
+	r.Put("/builds/{name}/{version}/artifact", putArtifactHandler)
+
+	r.Patch("/builds/{name}/{version}/status", setStatusHandler)
+
 	r.Delete("/builds/{name}/{version}", deleteBuildHandler)
 
+	r.Get("/badges/{name}/coverage.svg", badgeHandler(coverageBadge, badgeFormatSVG))
+	r.Get("/badges/{name}/coverage.json", badgeHandler(coverageBadge, badgeFormatJSON))
+	r.Get("/badges/{name}/version.svg", badgeHandler(versionBadge, badgeFormatSVG))
+	r.Get("/badges/{name}/version.json", badgeHandler(versionBadge, badgeFormatJSON))
+
+	r.Get("/coverage/leaderboard", getCoverageLeaderboardHandler)
+
+	r.Get("/retention/dryrun", getPrunableBuildsHandler)
+
 	r.Get("/builds/names", getNamesHandler)
+	r.Get("/builds/{name}/{version}/coverage/diff", getCoverageDiffHandler)
+	r.Get("/builds/{name}/{version}/coverage/files", getFileCoverageHandler)
+	r.Get("/builds/{name}/{version}/coverage/functions", getFunctionCoverageHandler)
 	r.Get("/builds/{name}/{version}/coverage", getCoverageHandler)
+	r.Get("/builds/{name}/{version}/artifacts", getArtifactsHandler)
+	r.Get("/builds/{name}/{version}/artifact", getArtifactHandler)
+	r.Get("/builds/{name}/{version}/verify", verifyArtifactHandler)
+	r.Get("/builds/{name}/coverage/volatility", getCoverageVolatilityHandler)
 	r.Get("/builds/{name}/coverage", getCoverageTrendHandler)
 	r.Get("/builds/{name}/{version}", getBuildsHandler)
 	r.Get("/builds/{name}", getBuildsHandler)
 	r.Get("/builds", getBuildsHandler)
 
-	return allowRemoteHandler{r}
+	return allowRemoteHandler{requestIDHandler{authHandler{r}}, splitList(corsOrigins)}
 }
 
 func checkEnv() bool {
@@ -290,11 +702,34 @@ func checkEnv() bool {
 }
 
 func init() {
-	flag.BoolVar(&createTables, "createtables", false, "Create the required DB tables")
+	flag.BoolVar(&createTables, "createtables", false, "Create the required DB tables, or add new columns to them, and exit")
 	flag.BoolVar(&runCoverage, "coverage", false, "Run coverage and exit.")
+	flag.StringVar(&policyFile, "coveragepolicies", "", "A JSON file of coverage policies to check new builds against")
+	flag.StringVar(&validationPolicyFile, "validationpolicies", "", "A JSON file of extra validation rules for new builds")
+	flag.BoolVar(&requireChecksums, "require-checksums", true, "Require the BinarySHA256 and BinarySize of new builds with a BinaryURL")
+	flag.StringVar(&retentionPolicyFile, "retentionpolicies", "", "A JSON file of the builds to keep, the rest being pruned")
+	flag.Int64Var(&maxBodySize, "maxbodysize", defaultMaxBodySize, "The maximum size in bytes of a request body")
+	flag.BoolVar(&strictJSON, "strictjson", false, "Refuse request bodies with unknown fields")
 	flag.IntVar(&listenPort, "port", defaultPort, "The listening port to bind HTTP to (default "+strconv.Itoa(defaultPort)+")")
 	flag.BoolVar(&outputName, "name", false, "Print service name and exit.")
 	flag.StringVar(&tlsListAddr, "tls", defaultTlsAddr, "The listening address to bind TLS to (default "+defaultTlsAddr+")")
+	flag.StringVar(&tlsCertFile, "tls-cert", "", "A PEM file of the TLS certificate, reloaded when it changes")
+	flag.StringVar(&tlsKeyFile, "tls-key", "", "A PEM file of the TLS private key, reloaded when it changes")
+	flag.StringVar(&tlsClientCAFile, "tls-client-ca", "", "A PEM bundle of CAs to verify TLS client certificates against")
+	flag.StringVar(&clientPolicyFile, "clientpolicies", "", "A JSON file of the actions allowed to each client")
+	flag.BoolVar(&requireTokens, "require-tokens", false, "Require an API token, or client certificate, for every request")
+	flag.BoolVar(&publicBadges, "public-badges", true, "Serve badges to anyone, even when authorization is enabled")
+	flag.StringVar(&corsOrigins, "cors-origins", "*", "A comma separated list of the origins allowed to make cross-origin requests")
+	flag.StringVar(&publisherSecretFile, "publishersecrets", "", "A JSON file of the secrets publishers must sign new builds with")
+	flag.DurationVar(&signatureMaxAge, "signature-max-age", defaultSignatureMaxAge, "How long a signed build is accepted for")
+	flag.DurationVar(&purgeAfter, "purge-after", defaultPurgeAfter, "How long deleted builds can be restored for before they're purged, or 0 to keep them")
+	flag.StringVar(&artifactDir, "artifact-dir", "", "A directory to store uploaded build artifacts in, enabling artifact uploads")
+	flag.StringVar(&artifactBaseURL, "artifact-url", "", "The base URL of the service in the BinaryURL of uploaded artifacts, required with -artifact-dir")
+	flag.Int64Var(&maxArtifactSize, "max-artifact-size", defaultMaxArtifactSize, "The maximum size in bytes of an uploaded artifact")
+	flag.DurationVar(&verifyInterval, "verify-interval", 0, "How often to fetch binaries and check them against their checksums, or 0 not to")
+	flag.DurationVar(&verifyTimeout, "verify-timeout", defaultVerifyTimeout, "How long fetching a binary to verify can take, or 0 for no limit")
+	flag.BoolVar(&disableHTTP, "disable-http", false, "Don't listen for plain HTTP")
+	flag.BoolVar(&disableTLS, "disable-tls", false, "Don't listen for TLS")
 	flag.BoolVar(&outputVersion, "version", false, "Print version and exit.")
 
 }
@@ -317,11 +752,16 @@ func main() {
 		return
 	}
 
+	if flag.Arg(0) == "upload-coverage" {
+		coverage_parser.UploadMain(flag.Args()[1:])
+		return
+	}
+
 	if !checkEnv() {
 		return
 	}
 
-	repo := new(sqlRepo)
+	repo := &sqlRepo{migrate: createTables}
 	err := repo.Connect(os.Getenv(envSqlServer), os.Getenv(envSqlPort), os.Getenv(envSqlUsername), os.Getenv(envSqlPassword), os.Getenv(envSqlDatabase))
 	if err != nil {
 		log.Println(err)
@@ -329,62 +769,145 @@ func main() {
 	}
 
 	if createTables {
-		log.Println("Creating tables")
-		err := repo.CreateTables()
+		// Connect has already created and migrated the tables
+		log.Println("Tables are up to date")
+		return
+	}
+
+	buildRepo = repo
+
+	if flag.Arg(0) == "create-token" {
+		createTokenMain(flag.Args()[1:])
+		return
+	}
+
+	if policyFile != "" {
+		coveragePolicies, err = loadCoveragePolicies(policyFile)
 		if err != nil {
 			log.Println(err)
 			return
 		}
-		log.Println("OK")
-		return
 	}
 
-	buildRepo = repo
-	commitRepo = NewGithubRepo(os.Getenv(envGithubToken))
+	if validationPolicyFile != "" {
+		validationPolicies, err = loadValidationPolicies(validationPolicyFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
 
-	r := router()
-	s := http.Server{
-		Addr:         tlsListAddr,
-		Handler:      r,
-		ReadTimeout:  defaultReadTimeout,
-		WriteTimeout: defaultWriteTimeout,
+	if artifactDir != "" {
+		if artifactBaseURL, err = parseArtifactBaseURL(artifactBaseURL); err != nil {
+			log.Println(err)
+			return
+		}
+		if artifactStore, err = newFileBlobStore(artifactDir); err != nil {
+			log.Println(err)
+			return
+		}
 	}
 
-	c := bytes.NewBufferString(defaultCert)
-	k := bytes.NewBufferString(defaultKey)
+	if retentionPolicyFile != "" {
+		retentionPolicies, err = loadRetentionPolicies(retentionPolicyFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
 
-	go func() {
-		log.Printf("Binding HTTP to %v\n", listenPort)
-		s := http.Server{
-			Addr:         fmt.Sprintf(":%v", listenPort),
-			Handler:      r,
-			ReadTimeout:  defaultReadTimeout,
-			WriteTimeout: defaultWriteTimeout,
+	if disableHTTP && disableTLS {
+		log.Println("At most one of -disable-http and -disable-tls can be set")
+		return
+	}
+
+	var certs *certReloader
+	var clientCAs *x509.CertPool
+	if !disableTLS {
+		if tlsCertFile == "" || tlsKeyFile == "" {
+			log.Println("-tls-cert and -tls-key are required unless -disable-tls is set")
+			return
 		}
-		log.Fatal(s.ListenAndServe())
-	}()
 
-	log.Println("Binding TLS to ", tlsListAddr)
-	log.Fatal(ListenAndServeTLS(s, c, k, r, tlsListAddr))
-}
+		certs, err = newCertReloader(tlsCertFile, tlsKeyFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-func ListenAndServeTLS(s http.Server, c *bytes.Buffer, k *bytes.Buffer, h http.Handler, listenAddr string) (err error) {
-	config := &tls.Config{}
-	config.NextProtos = []string{"http/1.1"}
+		if tlsClientCAFile != "" {
+			clientCAs, err = loadClientCAs(tlsClientCAFile)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+	}
 
-	config.Certificates = make([]tls.Certificate, 1)
-	config.Certificates[0], err = tls.X509KeyPair(c.Bytes(), k.Bytes())
-	if err != nil {
-		return err
+	if tlsClientCAFile != "" && clientPolicyFile == "" {
+		log.Println("-clientpolicies is required with -tls-client-ca")
+		return
+	}
+	if clientPolicyFile != "" {
+		clientPolicies, err = loadClientPolicies(clientPolicyFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
 	}
 
-	var ln net.Listener
-	ln, err = net.Listen("tcp", listenAddr)
-	if err != nil {
-		return err
+	if publisherSecretFile != "" {
+		publisherSecrets, err = loadPublisherSecrets(publisherSecretFile)
+		if err != nil {
+			log.Println(err)
+			return
+		}
 	}
 
-	tlsListener := tls.NewListener(ln, config)
+	commitRepo = NewGithubRepo(os.Getenv(envGithubToken))
+
+	if purgeAfter > 0 {
+		go runPurger(purgeAfter, defaultPurgeInterval)
+	}
+	if retentionPolicies != nil {
+		go runPruner(defaultPruneInterval)
+	}
+	if verifyInterval > 0 {
+		var fetcher ArtifactFetcher = newHTTPFetcher(verifyTimeout)
+		if artifactStore != nil {
+			fetcher = &storeFetcher{store: artifactStore, next: fetcher}
+		}
+		go runVerifier(fetcher, verifyInterval)
+	}
+
+	r := router()
+	errc := make(chan error, 2)
+
+	if !disableHTTP {
+		go func() {
+			log.Printf("Binding HTTP to %v\n", listenPort)
+			s := &http.Server{
+				Addr:         fmt.Sprintf(":%v", listenPort),
+				Handler:      r,
+				ReadTimeout:  defaultReadTimeout,
+				WriteTimeout: defaultWriteTimeout,
+			}
+			errc <- s.ListenAndServe()
+		}()
+	}
+
+	if !disableTLS {
+		go func() {
+			log.Println("Binding TLS to ", tlsListAddr)
+			s := &http.Server{
+				Addr:         tlsListAddr,
+				Handler:      r,
+				ReadTimeout:  defaultReadTimeout,
+				WriteTimeout: defaultWriteTimeout,
+			}
+			errc <- serveTLS(s, tlsConfig(certs, clientCAs))
+		}()
+	}
 
-	return s.Serve(tlsListener)
+	log.Fatal(<-errc)
 }
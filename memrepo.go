@@ -1,6 +1,8 @@
 package main
 
 import (
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/HailoOSS/build-service/models"
@@ -8,40 +10,302 @@ import (
 
 type memoryRepo struct {
 	builds []*models.Build
+	tokens []*models.APIToken
+	audit  []*models.AuditEvent
 	called string
 	filter string
 }
 
-func (r *memoryRepo) Create(b *models.Build) error {
+// addAuditEvent records a change to a build, as it was before the change
+func (r *memoryRepo) addAuditEvent(audit *models.AuditEvent, before *models.Build) {
+	if audit == nil {
+		return
+	}
+	if before != nil {
+		snapshot := *before
+		audit.Before = &snapshot
+	}
+	audit.ID = int64(len(r.audit) + 1)
+	r.audit = append(r.audit, audit)
+}
+
+func (r *memoryRepo) Create(b *models.Build, audit *models.AuditEvent) error {
 	r.called = "Create"
+	for _, existing := range r.builds {
+		if existing.Name == b.Name && existing.Version == b.Version {
+			return errBuildExists
+		}
+	}
 	r.builds = append(r.builds, b)
+	r.addAuditEvent(audit, nil)
 	return nil
 }
 
-func (r *memoryRepo) GetAll(limit int) ([]*models.Build, error) {
+func (r *memoryRepo) GetAll(status string, includeDeleted bool, limit int) ([]*models.Build, error) {
 	r.called = "GetAll"
-	return r.builds, nil
+	r.filter = status
+
+	builds := make([]*models.Build, 0)
+	for _, b := range r.builds {
+		if (status == "" || b.Status == status) && (includeDeleted || b.Deleted == nil) && len(builds) < limit {
+			builds = append(builds, b)
+		}
+	}
+	return builds, nil
 }
 
-func (r *memoryRepo) GetAllWithName(name string, limit int) ([]*models.Build, error) {
+func (r *memoryRepo) GetAllWithName(name, branch, status string, includeDeleted bool, limit int) ([]*models.Build, error) {
 	r.called = "GetAllWithName"
-	return nil, nil
+
+	builds := make([]*models.Build, 0)
+	for _, b := range r.builds {
+		if b.Name == name && (branch == "" || b.Branch == branch) && (status == "" || b.Status == status) && (includeDeleted || b.Deleted == nil) && len(builds) < limit {
+			builds = append(builds, b)
+		}
+	}
+	return builds, nil
+}
+
+func (r *memoryRepo) GetOlderWithName(name string, timestamp int64, version string, limit int) ([]*models.Build, error) {
+	r.called = "GetOlderWithName"
+
+	builds := make([]*models.Build, 0)
+	for _, b := range r.builds {
+		if b.Name == name && b.Deleted == nil && (version == "" || olderBuild(b, timestamp, version)) {
+			builds = append(builds, b)
+		}
+	}
+	sort.Sort(byNewest(builds))
+	if len(builds) > limit {
+		builds = builds[:limit]
+	}
+	return builds, nil
 }
 
 func (r *memoryRepo) GetVersion(name, version string) (*models.Build, error) {
 	r.called = "GetVersion"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryRepo) GetLatest(name, branch string) (*models.Build, error) {
+	r.called = "GetLatest"
+	var latest *models.Build
+	for _, b := range r.builds {
+		if b.Name != name || (branch != "" && b.Branch != branch) || !b.Succeeded() || b.Deleted != nil {
+			continue
+		}
+		if latest == nil || b.TimeStamp > latest.TimeStamp {
+			latest = b
+		}
+	}
+	return latest, nil
+}
+
+func (r *memoryRepo) GetLatestBefore(name, branch string, before int64) (*models.Build, error) {
+	r.called = "GetLatestBefore"
+	var latest *models.Build
+	for _, b := range r.builds {
+		if b.Name != name || (branch != "" && b.Branch != branch) || !b.Succeeded() || b.Deleted != nil || b.TimeStamp >= before {
+			continue
+		}
+		if latest == nil || b.TimeStamp > latest.TimeStamp {
+			latest = b
+		}
+	}
+	return latest, nil
+}
+
+func (r *memoryRepo) SetStatus(b *models.Build, previous string, audit *models.AuditEvent) error {
+	r.called = "SetStatus"
+	for i, existing := range r.builds {
+		if existing.Name == b.Name && existing.Version == b.Version {
+			if existing.Status != previous {
+				return errStatusChanged
+			}
+			r.addAuditEvent(audit, existing)
+			r.builds[i] = b
+			return nil
+		}
+	}
+	return errStatusChanged
+}
+
+func (r *memoryRepo) SetBinary(name, version, binaryURL, sha256 string, size int64, audit *models.AuditEvent) error {
+	r.called = "SetBinary"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			r.addAuditEvent(audit, b)
+			b.BinaryURL, b.BinarySHA256, b.BinarySize = binaryURL, sha256, size
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) SetArtifact(name, version string, a *models.Artifact, audit *models.AuditEvent) error {
+	r.called = "SetArtifact"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			r.addAuditEvent(audit, b)
+			b.SetArtifact(*a)
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) SetVerification(name, version string, v *models.Verification) error {
+	r.called = "SetVerification"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			b.Verification = v
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) CreateToken(t *models.APIToken) error {
+	r.called = "CreateToken"
+	r.tokens = append(r.tokens, t)
+	return nil
+}
+
+func (r *memoryRepo) GetToken(hash string) (*models.APIToken, error) {
+	for _, t := range r.tokens {
+		if t.Hash == hash {
+			return t, nil
+		}
+	}
 	return nil, nil
 }
 
-func (r *memoryRepo) Delete(name, version string) error {
+func (r *memoryRepo) GetTokens() ([]*models.APIToken, error) {
+	r.called = "GetTokens"
+	return r.tokens, nil
+}
+
+func (r *memoryRepo) RevokeToken(id string, at int64) (bool, error) {
+	r.called = "RevokeToken"
+	for _, t := range r.tokens {
+		if t.ID == id && t.RevokedAt == 0 {
+			t.RevokedAt = at
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRepo) Delete(name, version string, d *models.Deletion, audit *models.AuditEvent) error {
 	r.called = "Delete"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version && b.Deleted == nil {
+			r.addAuditEvent(audit, b)
+			b.Deleted = d
+		}
+	}
 	return nil
 }
 
+func (r *memoryRepo) Restore(name, version string, audit *models.AuditEvent) (bool, error) {
+	r.called = "Restore"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version && b.Deleted != nil {
+			r.addAuditEvent(audit, b)
+			b.Deleted = nil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryRepo) Purge(deletedBefore int64, audit *models.AuditEvent) ([]*models.Build, error) {
+	r.called = "Purge"
+	builds := make([]*models.Build, 0, len(r.builds))
+	purged := make([]*models.Build, 0)
+	for _, b := range r.builds {
+		if b.Deleted == nil || b.Deleted.Time >= deletedBefore {
+			builds = append(builds, b)
+			continue
+		}
+		event := *audit
+		event.Service, event.Version = b.Name, b.Version
+		r.addAuditEvent(&event, b)
+		purged = append(purged, b)
+	}
+	r.builds = builds
+	return purged, nil
+}
+
+func (r *memoryRepo) Prune(name, version string, audit *models.AuditEvent) (*models.Build, error) {
+	r.called = "Prune"
+	for i, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			r.addAuditEvent(audit, b)
+			r.builds = append(r.builds[:i], r.builds[i+1:]...)
+			return b, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryRepo) GetLatestBuilds(prefix, branch string, before int64) ([]*models.Build, error) {
+	r.called = "GetLatestBuilds"
+	latest := make(map[[2]string]*models.Build) // Name, branch => build
+	keys := make([][2]string, 0)
+	for _, b := range r.builds {
+		if !strings.HasPrefix(b.Name, prefix) || (branch != "" && b.Branch != branch) || !b.Succeeded() || b.Deleted != nil || (before != 0 && b.TimeStamp >= before) {
+			continue
+		}
+		key := [2]string{b.Name, b.Branch}
+		l, ok := latest[key]
+		if !ok {
+			keys = append(keys, key)
+		}
+		if !ok || b.TimeStamp > l.TimeStamp {
+			latest[key] = b
+		}
+	}
+
+	builds := make([]*models.Build, 0, len(keys))
+	for _, key := range keys {
+		builds = append(builds, latest[key])
+	}
+	return builds, nil
+}
+
+func (r *memoryRepo) GetNamesWithPrefix(prefix string) ([]string, error) {
+	r.called = "GetNamesWithPrefix"
+	r.filter = prefix
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, b := range r.builds {
+		if strings.HasPrefix(b.Name, prefix) && b.Deleted == nil && !seen[b.Name] {
+			seen[b.Name] = true
+			names = append(names, b.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func (r *memoryRepo) GetNames(filter string) ([]string, error) {
 	r.called = "GetNames"
 	r.filter = filter
-	return []string{}, nil
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, b := range r.builds {
+		if strings.Contains(b.Name, filter) && b.Deleted == nil && !seen[b.Name] {
+			seen[b.Name] = true
+			names = append(names, b.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
 }
 
 func (r *memoryRepo) GetCoverage(name, version string) (map[string]float64, error) {
@@ -49,16 +313,64 @@ func (r *memoryRepo) GetCoverage(name, version string) (map[string]float64, erro
 	return nil, nil
 }
 
-func (r *memoryRepo) GetCoverageTrend(name string, since time.Time) (models.CoverageSnapshots, error) {
-	r.called = "GetCoverageTrend"
+func (r *memoryRepo) SetCoverage(name, version string, c *models.CoverageReport, audit *models.AuditEvent) error {
+	r.called = "SetCoverage"
+	for _, b := range r.builds {
+		if b.Name == name && b.Version == version {
+			r.addAuditEvent(audit, b)
+			b.CoverageReport = *c
+		}
+	}
+	return nil
+}
+
+func (r *memoryRepo) GetFileCoverage(name, version string, limit int) ([]models.FileCoverage, error) {
+	r.called = "GetFileCoverage"
+	return nil, nil
+}
+
+func (r *memoryRepo) GetFunctionCoverage(name, version string, limit int) ([]models.FunctionCoverage, error) {
+	r.called = "GetFunctionCoverage"
 	return nil, nil
 }
 
+func (r *memoryRepo) GetCoverageTrend(name, branch string, since, until time.Time) (models.CoverageSnapshots, error) {
+	r.called = "GetCoverageTrend"
+
+	var snapshots models.CoverageSnapshots
+	for _, b := range r.builds {
+		if b.Name != name || (branch != "" && b.Branch != branch) || b.Deleted != nil || b.TimeStamp <= since.Unix() || b.TimeStamp > until.Unix() {
+			continue
+		}
+		snapshots = append(snapshots, models.CoverageSnapshot{
+			Coverages:     b.Coverages(),
+			TotalCoverage: models.AggregateCoverage(b.Coverages()),
+			Branch:        b.Branch,
+			Version:       b.Version,
+			Timestamp:     b.TimeStamp,
+		})
+	}
+	sort.Sort(snapshots)
+	return snapshots, nil
+}
+
 func (r *memoryRepo) SetMergeBaseDate(service, version, importPath, commit string, date time.Time) error {
 	r.called = "SetMergeBaseDate"
 	return nil
 }
 
+func (r *memoryRepo) GetAuditEvents(f *models.AuditFilter) ([]*models.AuditEvent, error) {
+	r.called = "GetAuditEvents"
+
+	events := make([]*models.AuditEvent, 0)
+	for i := len(r.audit) - 1; i >= 0 && len(events) < f.Limit; i-- {
+		if f.Matches(r.audit[i]) {
+			events = append(events, r.audit[i])
+		}
+	}
+	return events, nil
+}
+
 func newTestRepo() *memoryRepo {
 	return &memoryRepo{
 		builds: make([]*models.Build, 0),
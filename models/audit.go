@@ -0,0 +1,49 @@
+package models
+
+// The actions recorded in the audit log
+const (
+	AuditCreate   = "create"
+	AuditDelete   = "delete"
+	AuditStatus   = "status"
+	AuditCoverage = "coverage"
+	AuditRestore  = "restore"
+	AuditPurge    = "purge"
+	AuditPrune    = "prune"
+	AuditArtifact = "artifact"
+)
+
+// AuditEvent records a change to a build and who made it. Events are never
+// changed or deleted, even when the build is.
+type AuditEvent struct {
+	ID              int64
+	Time            int64  // UTC unix timestamp
+	Actor           string // The client which made the change
+	Action          string
+	Service         string
+	Version         string
+	RemoteAddr      string `json:",omitempty"`
+	RequestID       string `json:",omitempty"` // The ID the service gave the request
+	ClientRequestID string `json:",omitempty"` // The ID the client sent, which isn't checked
+	Before          *Build `json:",omitempty"` // The build before the change, if it existed
+}
+
+// AuditFilter selects audit events, ignoring blank fields
+type AuditFilter struct {
+	Actor   string
+	Action  string
+	Service string
+	Version string
+	Since   int64 // UTC unix timestamp, inclusive
+	Until   int64 // UTC unix timestamp, inclusive, or zero for now
+	Limit   int
+}
+
+// Matches returns whether an event is selected by the filter
+func (f *AuditFilter) Matches(e *AuditEvent) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Service == "" || e.Service == f.Service) &&
+		(f.Version == "" || e.Version == f.Version) &&
+		e.Time >= f.Since &&
+		(f.Until == 0 || e.Time <= f.Until)
+}
@@ -1,40 +1,388 @@
 package models
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 	"time"
 )
 
 // Build stores metadata relating to a specific build
 type Build struct {
-	Hostname       string               `validate:"nonblank"` // The hostname that did the build
-	Architecture   string               `validate:"nonblank"` // 386, AMD64 etc
-	GoVersion      string               `validate:""`         // Version of Go used to build the binary
-	SourceURL      string               `validate:"nonblank"` // The VCS url, down to the commit level
-	BinaryURL      string               `validate:"nonblank"` // The location of the binary or JAR
-	Version        string               `validate:"nonblank"` // Initially a human readable date. Eg. 20130601114431
-	Language       string               `validate:"nonblank"` // Programming language
-	Name           string               `validate:"nonblank"` // The service name
-	Branch         string               `validate:"nonblank"` // The Git branch
+	Hostname       string               `validate:"nonblank,maxlen=255"`          // The hostname that did the build
+	Architecture   string               `validate:"nonblank,maxlen=10"`           // 386, AMD64 etc
+	GoVersion      string               `validate:"maxlen=255"`                   // Version of Go used to build the binary
+	SourceURL      string               `validate:"nonblank,url,maxlen=255"`      // The VCS url, down to the commit level
+	BinaryURL      string               `validate:"url=http|https|s3,maxlen=255"` // The location of the binary or JAR, required once the build has succeeded
+	BinarySHA256   string               `json:",omitempty" validate:"sha256"`     // The hex SHA-256 of the binary
+	BinarySize     int64                `json:",omitempty" validate:"min=0"`      // The size of the binary in bytes
+	Version        string               `validate:"nonblank,timestamp"`           // Initially a human readable date. Eg. 20130601114431
+	Language       string               `validate:"nonblank,oneof=Go|Java"`       // Programming language
+	Name           string               `validate:"nonblank,maxlen=255"`          // The service name
+	Branch         string               `validate:"nonblank,maxlen=255"`          // The Git branch
 	TimeStamp      int64                // UTC unix timestamp
-	Coverage       map[string]float64   `json:"Coverage,omitempty"` // The code coverage as package => percentage
-	Dependencies   map[string]string    `json:",omitempty"`         // The dependencies as importPath => commit
-	MergeBaseDates map[string]time.Time `json:",omitempty"`         // The merge base dates of dependency commits
+	Status         string               `json:",omitempty" validate:"oneof=queued|running|succeeded|failed|cancelled"` // Where the build is up to, succeeded if blank
+	StartedAt      int64                `json:",omitempty"`                                                            // When the build started running as a UTC unix timestamp
+	FinishedAt     int64                `json:",omitempty"`                                                            // When the build finished as a UTC unix timestamp
+	FailureReason  string               `json:",omitempty" validate:"maxlen=1024"`                                     // Why the build failed or was cancelled
+	TotalCoverage  float64              // Coverage of the whole service, weighted by statements
+	Dependencies   map[string]string    `json:",omitempty" validate:"dive,keys,importpath,endkeys,sha1"` // The dependencies as importPath => commit
+	MergeBaseDates map[string]time.Time `json:",omitempty"`                                              // The merge base dates of dependency commits
+	CoverageGate   *CoverageGate        `json:",omitempty"`                                              // The result of checking the coverage against a policy
+	Deleted        *Deletion            `json:",omitempty"`                                              // Who deleted the build and why, if it's been deleted
+	Verification   *Verification        `json:",omitempty"`                                              // The last check of the binary against its checksum
+	Artifacts      []Artifact           `json:",omitempty" validate:"dive"`                              // The files built for each platform, as well as BinaryURL
+	CoverageReport                      // The code coverage of the build
 }
 
-type CoverageSnapshot struct {
-	Coverages []Coverage
-	Branch    string
+// CoverageReport is the code coverage of a build. It's sent with a new build,
+// or attached to an existing build afterwards.
+type CoverageReport struct {
+	Coverage     map[string]float64        `json:"Coverage,omitempty" validate:"dive,min=0,max=100"` // The code coverage as package => percentage
+	Statements   map[string]StatementCount `json:",omitempty"`                                       // The statement counts as package => covered/total
+	CoverProfile string                    `json:",omitempty"`                                       // A raw Go cover profile, parsed into Files when received
+	Files        []FileCoverage            `json:",omitempty" validate:"dive"`                       // The code coverage of each source file, only set when received
+	Functions    []FunctionCoverage        `json:",omitempty" validate:"dive"`                       // The code coverage of each function, only set when received
+}
+
+// CoverageGate is the result of checking the coverage of a build against a
+// coverage policy when it was created
+type CoverageGate struct {
+	Policy     string   // The name prefix of the policy applied
+	Mode       string   // What happens when the gate fails, warn or reject
+	Passed     bool     // Whether the coverage met the policy
+	Violations []string `json:",omitempty"` // The reasons the gate failed
+}
+
+// Deletion records that a build was deleted. Deleted builds are hidden, and
+// can be restored until they're purged.
+type Deletion struct {
+	Time   int64  // UTC unix timestamp
+	Actor  string // The client which deleted the build
+	Reason string `json:",omitempty" validate:"maxlen=1024"`
+}
+
+// The results of verifying a binary
+const (
+	VerifyOK       = "ok"
+	VerifyMismatch = "mismatch"
+	VerifyMissing  = "missing"
+)
+
+// Verification is the result of checking a binary against the checksum and
+// size of its build
+type Verification struct {
+	Time   int64  // UTC unix timestamp
+	Result string // ok, mismatch or missing
+	Detail string `json:",omitempty"` // Why the binary didn't match
+}
+
+// Verify returns the result of checking a binary's checksum and size against
+// the build. A negative size isn't checked.
+func (b *Build) Verify(sha256 string, size int64) *Verification {
+	return b.Binary().Verify(sha256, size)
+}
+
+// The kinds of artifact
+const (
+	ArtifactBinary  = "binary"
+	ArtifactJar     = "jar"
+	ArtifactDocker  = "docker"
+	ArtifactTarball = "tarball"
+)
+
+// Artifact is a file built for one platform. A build has at most one
+// artifact of each kind for an OS and architecture.
+type Artifact struct {
+	OS           string `validate:"nonblank,maxlen=32,regex=^[a-zA-Z0-9_]+$"` // linux, darwin etc
+	Architecture string `validate:"nonblank,maxlen=10,regex=^[a-zA-Z0-9_]+$"` // 386, amd64, arm64 etc
+	Kind         string `validate:"nonblank,oneof=binary|jar|docker|tarball"`
+	URL          string `validate:"nonblank,url=http|https|s3|docker,maxlen=255"` // Docker images are docker://registry/image:tag
+	SHA256       string `validate:"nonblank,sha256"`                              // The hex SHA-256 of the file, or the digest of the image
+	Size         int64  `json:",omitempty" validate:"min=0"`                      // The size of the file in bytes
+}
+
+// BuildArtifacts are the artifacts of a build which matched a query
+type BuildArtifacts struct {
+	Name      string
 	Version   string
-	Timestamp int64
+	Branch    string
+	Artifacts []Artifact
+}
+
+// Key identifies the platform and kind of the artifact within its build
+func (a *Artifact) Key() string {
+	return strings.ToLower(a.OS + "/" + a.Architecture + "/" + a.Kind)
+}
+
+// Matches returns whether the artifact is for the OS, architecture and kind,
+// ignoring case. Blank values match any artifact. An artifact without an OS,
+// such as the BinaryURL, only matches when no OS is asked for, as it could be
+// for any OS.
+func (a *Artifact) Matches(os, arch, kind string) bool {
+	return (os == "" || strings.EqualFold(a.OS, os)) &&
+		(arch == "" || strings.EqualFold(a.Architecture, arch)) &&
+		(kind == "" || strings.EqualFold(a.Kind, kind))
+}
+
+// Verify returns the result of checking a file's checksum and size against
+// the artifact. A negative size isn't checked.
+func (a *Artifact) Verify(sha256 string, size int64) *Verification {
+	v := &Verification{Result: VerifyOK}
+	if !strings.EqualFold(sha256, a.SHA256) {
+		v.Result = VerifyMismatch
+		v.Detail = fmt.Sprintf("SHA-256 is %s, expected %s", sha256, a.SHA256)
+	} else if size >= 0 && size != a.Size {
+		v.Result = VerifyMismatch
+		v.Detail = fmt.Sprintf("Size is %d bytes, expected %d", size, a.Size)
+	}
+	return v
+}
+
+// Binary returns the BinaryURL of the build as an artifact, which has no OS
+func (b *Build) Binary() *Artifact {
+	kind := ArtifactBinary
+	if b.Language == "Java" {
+		kind = ArtifactJar
+	}
+	return &Artifact{Architecture: b.Architecture, Kind: kind, URL: b.BinaryURL, SHA256: b.BinarySHA256, Size: b.BinarySize}
+}
+
+// FindArtifacts returns the artifacts of the build which match the OS,
+// architecture and kind, followed by its BinaryURL if that matches and isn't
+// one of its artifacts
+func (b *Build) FindArtifacts(os, arch, kind string) []Artifact {
+	artifacts := make([]Artifact, 0)
+	listed := false
+	for _, a := range b.Artifacts {
+		listed = listed || a.URL == b.BinaryURL
+		if a.Matches(os, arch, kind) {
+			artifacts = append(artifacts, a)
+		}
+	}
+	if binary := b.Binary(); b.BinaryURL != "" && !listed && binary.Matches(os, arch, kind) {
+		artifacts = append(artifacts, *binary)
+	}
+	return artifacts
+}
+
+// SetArtifact adds an artifact to the build, replacing any of the same kind
+// for the same OS and architecture. The list is copied rather than changed in
+// place, as copies of a build share it.
+func (b *Build) SetArtifact(a Artifact) {
+	artifacts := append([]Artifact(nil), b.Artifacts...)
+	for i := range artifacts {
+		if artifacts[i].Key() == a.Key() {
+			artifacts[i] = a
+			b.Artifacts = artifacts
+			return
+		}
+	}
+	b.Artifacts = append(artifacts, a)
+}
+
+// StatementCount is the number of covered and total statements in a package
+type StatementCount struct {
+	Covered int64
+	Total   int64
+}
+
+// Coverages returns the package coverage of the report, including statement
+// counts where they are known
+func (c *CoverageReport) Coverages() []Coverage {
+	coverages := make([]Coverage, 0, len(c.Coverage))
+	for pkg, percentage := range c.Coverage {
+		sc := c.Statements[pkg]
+		coverages = append(coverages, Coverage{
+			PackageName: pkg,
+			Percentage:  percentage,
+			Covered:     sc.Covered,
+			Statements:  sc.Total,
+		})
+	}
+	sort.Sort(byPackageName(coverages))
+	return coverages
+}
+
+type CoverageSnapshot struct {
+	Coverages     []Coverage
+	TotalCoverage float64
+	Branch        string
+	Version       string
+	Timestamp     int64
+	Builds        int `json:",omitempty"` // The number of builds aggregated into the snapshot
 }
 
 type CoverageSnapshots []CoverageSnapshot
 
-func (c CoverageSnapshots) Len() int           { return len(c) }
-func (c CoverageSnapshots) Less(i, j int) bool { return c[i].Timestamp < c[j].Timestamp }
-func (c CoverageSnapshots) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c CoverageSnapshots) Len() int { return len(c) }
+func (c CoverageSnapshots) Less(i, j int) bool {
+	if c[i].Timestamp != c[j].Timestamp {
+		return c[i].Timestamp < c[j].Timestamp
+	}
+	if c[i].Branch != c[j].Branch {
+		return c[i].Branch < c[j].Branch
+	}
+	return c[i].Version < c[j].Version
+}
+func (c CoverageSnapshots) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
 
 type Coverage struct {
 	PackageName string
 	Percentage  float64
+	Covered     int64 `json:",omitempty"` // Number of covered statements
+	Statements  int64 `json:",omitempty"` // Total number of statements
+}
+
+type byPackageName []Coverage
+
+func (c byPackageName) Len() int           { return len(c) }
+func (c byPackageName) Less(i, j int) bool { return c[i].PackageName < c[j].PackageName }
+func (c byPackageName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// AggregateCoverage returns the coverage of a set of packages weighted by
+// their number of statements. Packages without statement counts are ignored,
+// unless no counts are known at all, in which case it falls back
+// to the mean of the package percentages.
+func AggregateCoverage(coverages []Coverage) float64 {
+	if len(coverages) == 0 {
+		return 0
+	}
+
+	var covered, statements int64
+	var sum float64
+	for _, c := range coverages {
+		covered += c.Covered
+		statements += c.Statements
+		sum += c.Percentage
+	}
+
+	if statements > 0 {
+		return Percentage(covered, statements)
+	}
+	return Round(sum / float64(len(coverages)))
+}
+
+// Percentage returns covered as a percentage of statements, rounded to two
+// decimal places
+func Percentage(covered, statements int64) float64 {
+	if statements == 0 {
+		return 0
+	}
+	return Round(float64(covered) / float64(statements) * 100)
+}
+
+// Round rounds to two decimal places, matching what is stored
+func Round(f float64) float64 {
+	return math.Floor(f*100+0.5) / 100
+}
+
+// FileCoverage is the code coverage of a single source file
+type FileCoverage struct {
+	FileName   string
+	Covered    int64
+	Statements int64
+	Percentage float64 `validate:"min=0,max=100"`
+}
+
+// FunctionCoverage is the code coverage of a single function, as reported by
+// go tool cover -func
+type FunctionCoverage struct {
+	FileName     string
+	Line         int
+	FunctionName string
+	Percentage   float64 `validate:"min=0,max=100"`
+}
+
+// CoverageDiff is the change in coverage of a build compared to another
+type CoverageDiff struct {
+	Name                 string
+	Version              string
+	Against              string        // The version compared against
+	TotalCoverage        float64       // The total coverage of Version
+	AgainstTotalCoverage float64       // The total coverage of Against
+	TotalChange          float64       // The change in total coverage
+	Packages             []PackageDiff // Packages in both builds
+	Added                []Coverage    // Packages only in Version
+	Removed              []Coverage    // Packages only in Against
+}
+
+// PackageDiff is the change in coverage of a package between two builds
+type PackageDiff struct {
+	PackageName       string
+	Percentage        float64
+	AgainstPercentage float64
+	Change            float64
+}
+
+// DiffCoverage compares the coverage of b to the coverage of against
+func DiffCoverage(b, against *Build) *CoverageDiff {
+	diff := &CoverageDiff{
+		Name:                 b.Name,
+		Version:              b.Version,
+		Against:              against.Version,
+		TotalCoverage:        AggregateCoverage(b.Coverages()),
+		AgainstTotalCoverage: AggregateCoverage(against.Coverages()),
+		Packages:             make([]PackageDiff, 0),
+		Added:                make([]Coverage, 0),
+		Removed:              make([]Coverage, 0),
+	}
+	diff.TotalChange = Round(diff.TotalCoverage - diff.AgainstTotalCoverage)
+
+	for _, c := range b.Coverages() {
+		perc, ok := against.Coverage[c.PackageName]
+		if !ok {
+			diff.Added = append(diff.Added, c)
+			continue
+		}
+		diff.Packages = append(diff.Packages, PackageDiff{
+			PackageName:       c.PackageName,
+			Percentage:        c.Percentage,
+			AgainstPercentage: perc,
+			Change:            Round(c.Percentage - perc),
+		})
+	}
+
+	for _, c := range against.Coverages() {
+		if _, ok := b.Coverage[c.PackageName]; !ok {
+			diff.Removed = append(diff.Removed, c)
+		}
+	}
+
+	return diff
+}
+
+// ServiceCoverage is the coverage of the latest build of a service
+type ServiceCoverage struct {
+	Rank             int
+	Name             string
+	Version          string
+	Branch           string
+	TotalCoverage    float64
+	Change           float64 // The change in total coverage over the period of the summary
+	Packages         int
+	UntestedPackages int // Packages with no coverage
+}
+
+// CoverageSummary ranks the coverage of many services
+type CoverageSummary struct {
+	Since                   int64   // The start of the period changes are measured over
+	TotalCoverage           float64 // Coverage across all ranked services, weighted by statements
+	UntestedPackages        int
+	ServicesWithoutCoverage []string
+	Services                []ServiceCoverage
+}
+
+// VolatilePackage is a package whose coverage varied between builds of the
+// same commit, which usually means its tests are nondeterministic
+type VolatilePackage struct {
+	PackageName string
+	Branch      string
+	SourceURL   string   // The commit that was built
+	Versions    []string // The builds of the commit
+	Mean        float64
+	StdDev      float64 // The standard deviation of the coverage
+	Min         float64
+	Max         float64
 }
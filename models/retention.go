@@ -0,0 +1,10 @@
+package models
+
+// PruneCandidate is a build which a retention policy would remove
+type PruneCandidate struct {
+	Name      string
+	Version   string
+	Branch    string
+	TimeStamp int64
+	Policy    string // The service name prefix of the policy
+}
@@ -0,0 +1,97 @@
+package models
+
+import (
+	"fmt"
+)
+
+// The statuses of a build
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// statusTransitions are the statuses each status can change to
+var statusTransitions = map[string][]string{
+	StatusQueued:  {StatusRunning, StatusSucceeded, StatusFailed, StatusCancelled},
+	StatusRunning: {StatusSucceeded, StatusFailed, StatusCancelled},
+}
+
+// StatusUpdate changes the status of an existing build
+type StatusUpdate struct {
+	Status        string     `validate:"nonblank,oneof=queued|running|succeeded|failed|cancelled"`
+	FailureReason string     `json:",omitempty" validate:"maxlen=1024"`                  // Why a build failed or was cancelled
+	BinaryURL     string     `json:",omitempty" validate:"url=http|https|s3,maxlen=255"` // The binary of a build which has succeeded
+	BinarySHA256  string     `json:",omitempty" validate:"sha256"`                       // The hex SHA-256 of the binary
+	BinarySize    int64      `json:",omitempty" validate:"min=0"`                        // The size of the binary in bytes
+	Artifacts     []Artifact `json:",omitempty" validate:"dive"`                         // Files built for each platform, added to those of the build
+	Time          int64      `json:",omitempty"`                                         // When the status changed as a UTC unix timestamp, defaulting to now
+}
+
+// TransitionError is returned when a build can't change to a status
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("A %s build can't become %s", e.From, e.To)
+}
+
+// Finished returns whether the status is final
+func Finished(status string) bool {
+	return status == StatusSucceeded || status == StatusFailed || status == StatusCancelled
+}
+
+// Succeeded returns whether the build succeeded. Builds created before
+// statuses were recorded have none, and are successful.
+func (b *Build) Succeeded() bool {
+	return b.Status == "" || b.Status == StatusSucceeded
+}
+
+// Duration returns how long the build ran for in seconds, or zero if it
+// hasn't finished
+func (b *Build) Duration() int64 {
+	if b.StartedAt == 0 || b.FinishedAt < b.StartedAt {
+		return 0
+	}
+	return b.FinishedAt - b.StartedAt
+}
+
+// SetStatus applies a status update to the build, recording when it started
+// and finished
+func (b *Build) SetStatus(u *StatusUpdate) error {
+	from := b.Status
+	if from == "" {
+		from = StatusSucceeded
+	}
+
+	allowed := false
+	for _, s := range statusTransitions[from] {
+		allowed = allowed || s == u.Status
+	}
+	if !allowed {
+		return &TransitionError{From: from, To: u.Status}
+	}
+
+	if u.Status == StatusRunning && b.StartedAt == 0 {
+		b.StartedAt = u.Time
+	}
+	if Finished(u.Status) {
+		b.FinishedAt = u.Time
+	}
+	if u.BinaryURL != "" {
+		b.BinaryURL = u.BinaryURL
+		b.BinarySHA256 = u.BinarySHA256
+		b.BinarySize = u.BinarySize
+	}
+	for _, a := range u.Artifacts {
+		b.SetArtifact(a)
+	}
+
+	b.Status = u.Status
+	b.FailureReason = u.FailureReason
+
+	return nil
+}
@@ -0,0 +1,15 @@
+package models
+
+// APIToken grants a client permission to use the API. Only a hash of the
+// token itself is stored.
+type APIToken struct {
+	ID        string   // Identifies the token, eg to revoke it
+	Name      string   `validate:"nonblank,maxlen=255"`                                       // Who or what the token is for
+	Scopes    []string `validate:"nonblank,dive,oneof=read|builds:write|builds:delete|admin"` // The actions the token allows
+	Prefixes  []string `json:",omitempty" validate:"dive,nonblank,maxlen=255"`                // The service name prefixes which may be written or deleted, any if empty
+	CreatedAt int64    // UTC unix timestamp
+	CreatedBy string   `json:",omitempty"` // The client which created the token
+	RevokedAt int64    `json:",omitempty"` // UTC unix timestamp, zero if the token is valid
+	Token     string   `json:",omitempty"` // The token itself, only returned when it's created
+	Hash      string   `json:"-"`          // The SHA-256 of the token, in hex
+}
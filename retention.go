@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	defaultPruneInterval = time.Hour
+	prunerActor          = "pruner"
+)
+
+// retentionPageSize is the number of builds of a service read at once when
+// finding builds to prune
+var retentionPageSize = 1000
+
+// RetentionPolicy defines which builds of the services with a name prefix are
+// kept, optionally only on one branch. A build is kept if either rule keeps it.
+type RetentionPolicy struct {
+	Prefix   string // The service name prefix the policy applies to
+	Branch   string // The branch the policy applies to, or any branch if blank
+	KeepLast int    // The number of latest builds kept on each branch
+	KeepDays int    // Builds newer than this many days are kept
+}
+
+// RetentionPolicies is a set of policies, of which the most specific matching
+// one is applied to the builds of each branch. Builds without a policy are
+// kept.
+type RetentionPolicies []*RetentionPolicy
+
+// loadRetentionPolicies reads a JSON list of policies from a file
+func loadRetentionPolicies(path string) (RetentionPolicies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policies := make(RetentionPolicies, 0)
+	if err := json.NewDecoder(f).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Error decoding retention policies: %v", err)
+	}
+
+	for _, p := range policies {
+		if p.KeepLast < 0 || p.KeepDays < 0 {
+			return nil, fmt.Errorf("Negative retention for %q", p.Prefix)
+		}
+		if p.KeepLast == 0 && p.KeepDays == 0 {
+			return nil, fmt.Errorf("Retention policy for %q must keep some builds", p.Prefix)
+		}
+	}
+
+	return policies, nil
+}
+
+// Find returns the policy for a branch of a service, preferring the longest
+// prefix and then a branch specific policy, or nil if none match
+func (rp RetentionPolicies) Find(name, branch string) *RetentionPolicy {
+	var found *RetentionPolicy
+	for _, p := range rp {
+		if !strings.HasPrefix(name, p.Prefix) || (p.Branch != "" && p.Branch != branch) {
+			continue
+		}
+		if found == nil || len(p.Prefix) > len(found.Prefix) ||
+			(len(p.Prefix) == len(found.Prefix) && found.Branch == "" && p.Branch != "") {
+			found = p
+		}
+	}
+	return found
+}
+
+// olderBuild reports whether a build comes after the build with a timestamp
+// and version when ordered newest first
+func olderBuild(b *models.Build, timestamp int64, version string) bool {
+	return b.TimeStamp < timestamp || (b.TimeStamp == timestamp && b.Version < version)
+}
+
+type byNewest []*models.Build
+
+func (b byNewest) Len() int           { return len(b) }
+func (b byNewest) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNewest) Less(i, j int) bool { return olderBuild(b[j], b[i].TimeStamp, b[i].Version) }
+
+// Prunable returns the builds which the policies don't keep. Unfinished
+// builds and the latest successful build of each branch, which may be
+// deployed, are always kept.
+func (rp RetentionPolicies) Prunable(builds []*models.Build, now time.Time) []*models.PruneCandidate {
+	byBranch := make(map[string][]*models.Build)
+	keys := make([]string, 0)
+	for _, b := range builds {
+		if b.Deleted != nil {
+			continue
+		}
+		key := b.Name + "/" + b.Branch
+		if _, ok := byBranch[key]; !ok {
+			keys = append(keys, key)
+		}
+		byBranch[key] = append(byBranch[key], b)
+	}
+	sort.Strings(keys)
+
+	candidates := make([]*models.PruneCandidate, 0)
+	for _, key := range keys {
+		branchBuilds := byBranch[key]
+		sort.Stable(byNewest(branchBuilds))
+
+		p := rp.Find(branchBuilds[0].Name, branchBuilds[0].Branch)
+		if p == nil {
+			continue
+		}
+		keepAfter := now.AddDate(0, 0, -p.KeepDays).Unix()
+
+		latestSucceeded := false
+		for i, b := range branchBuilds {
+			if b.Succeeded() && !latestSucceeded {
+				latestSucceeded = true
+				continue
+			}
+			if i < p.KeepLast || (p.KeepDays > 0 && b.TimeStamp > keepAfter) || (b.Status != "" && !models.Finished(b.Status)) {
+				continue
+			}
+			candidates = append(candidates, &models.PruneCandidate{
+				Name:      b.Name,
+				Version:   b.Version,
+				Branch:    b.Branch,
+				TimeStamp: b.TimeStamp,
+				Policy:    p.Prefix,
+			})
+		}
+	}
+
+	return candidates
+}
+
+// findPrunableBuilds returns the builds of every service which the retention
+// policies don't keep
+func findPrunableBuilds(now time.Time) ([]*models.PruneCandidate, error) {
+	candidates := make([]*models.PruneCandidate, 0)
+	if retentionPolicies == nil {
+		return candidates, nil
+	}
+
+	names, err := buildRepo.GetNames("")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		builds, err := allBuildsWithName(name)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, retentionPolicies.Prunable(builds, now)...)
+	}
+
+	return candidates, nil
+}
+
+// allBuildsWithName reads every build of a service which isn't deleted, a
+// page at a time
+func allBuildsWithName(name string) ([]*models.Build, error) {
+	builds := make([]*models.Build, 0)
+	var timestamp int64
+	var version string
+	for {
+		page, err := buildRepo.GetOlderWithName(name, timestamp, version, retentionPageSize)
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, page...)
+		if len(page) < retentionPageSize {
+			return builds, nil
+		}
+
+		// The page isn't necessarily in order once coverage is joined
+		for _, b := range page {
+			if version == "" || olderBuild(b, timestamp, version) {
+				timestamp, version = b.TimeStamp, b.Version
+			}
+		}
+	}
+}
+
+// pruneBuilds permanently deletes the builds which the retention policies
+// don't keep, along with their artifacts, returning how many there were
+func pruneBuilds(now time.Time) (int, error) {
+	candidates, err := findPrunableBuilds(now)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, c := range candidates {
+		audit := &models.AuditEvent{Time: now.Unix(), Actor: prunerActor, Action: models.AuditPrune, Service: c.Name, Version: c.Version}
+		b, err := buildRepo.Prune(c.Name, c.Version, audit)
+		if err != nil {
+			return i, err
+		}
+		if b != nil {
+			deleteArtifacts(b)
+		}
+	}
+
+	return len(candidates), nil
+}
+
+// runPruner prunes builds every interval
+func runPruner(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		n, err := pruneBuilds(now)
+		if err != nil {
+			log.Printf("Failed to prune builds: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Pruned %d builds", n)
+		}
+	}
+}
+
+// getPrunableBuildsHandler lists the builds the pruner would remove, without
+// removing them
+func getPrunableBuildsHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET RETENTION_DRYRUN", r.URL)
+
+	if !authorize(rw, r, actionAdmin, "") {
+		return
+	}
+
+	candidates, err := findPrunableBuilds(time.Now())
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error finding builds to prune: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(candidates)
+}
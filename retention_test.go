@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestLoadRetentionPolicies(t *testing.T) {
+	testCases := []struct {
+		data        string
+		expectError bool
+	}{
+		{`[{"Prefix":"com.HailoOSS.","KeepLast":10},{"Prefix":"com.HailoOSS.kernel.","Branch":"master","KeepDays":90}]`, false},
+		{`[{"Prefix":"com.HailoOSS."}]`, true},
+		{`[{"Prefix":"com.HailoOSS.","KeepLast":-1,"KeepDays":30}]`, true},
+		{`not json`, true},
+	}
+
+	for i, tc := range testCases {
+		f, err := ioutil.TempFile("", "policies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.data)
+		f.Close()
+
+		_, err = loadRetentionPolicies(f.Name())
+		os.Remove(f.Name())
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+		}
+	}
+}
+
+// retentionBuilds returns builds of a service made days before now
+func retentionBuilds(now time.Time) []*models.Build {
+	builds := make([]*models.Build, 0)
+	for i, days := range []int{1, 2, 3, 10, 20, 30} {
+		b := validBuild()
+		b.Version = now.AddDate(0, 0, -days).Format("20060102150405")
+		b.TimeStamp = now.AddDate(0, 0, -days).Unix()
+		switch i {
+		case 0:
+			b.Status = models.StatusFailed
+		case 5:
+			b.Status = models.StatusRunning
+		}
+		builds = append(builds, &b)
+	}
+
+	deleted := validBuild()
+	deleted.Version = "20100101000000"
+	deleted.TimeStamp = now.AddDate(-1, 0, 0).Unix()
+	deleted.Deleted = &models.Deletion{Time: now.Unix()}
+
+	other := validBuild()
+	other.Name = "com.example.foo"
+	other.TimeStamp = now.AddDate(-1, 0, 0).Unix()
+
+	return append(builds, &deleted, &other)
+}
+
+func TestPrunable(t *testing.T) {
+	now := time.Now()
+	builds := retentionBuilds(now)
+	all := RetentionPolicies{{Prefix: "com.HailoOSS.", KeepLast: 2, KeepDays: 5}}
+
+	testCases := []struct {
+		policies RetentionPolicies
+		expected []*models.Build
+	}{
+		{all, []*models.Build{builds[3], builds[4]}},
+		{RetentionPolicies{{Prefix: "com.HailoOSS.", KeepLast: 1}}, []*models.Build{builds[2], builds[3], builds[4]}},
+		{append(all, &RetentionPolicy{Prefix: "com.HailoOSS.", Branch: "master", KeepDays: 15}), []*models.Build{builds[4]}},
+		{RetentionPolicies{{Prefix: "com.HailoOSS.", Branch: "release", KeepLast: 1}}, []*models.Build{}},
+	}
+
+	for i, tc := range testCases {
+		candidates := tc.policies.Prunable(builds, now)
+		if len(candidates) != len(tc.expected) {
+			t.Errorf("Expected %d builds, Got %d (%d)", len(tc.expected), len(candidates), i)
+			continue
+		}
+		for j, c := range candidates {
+			if c.Name != tc.expected[j].Name || c.Version != tc.expected[j].Version || c.Policy != "com.HailoOSS." {
+				t.Errorf("Expected %s, Got %+v (%d)", tc.expected[j].Version, c, i)
+			}
+		}
+	}
+}
+
+func TestPruneBuilds(t *testing.T) {
+	defer func() { retentionPolicies = nil }()
+	retentionPolicies = RetentionPolicies{{Prefix: "com.HailoOSS.", KeepLast: 2, KeepDays: 5}}
+
+	repo := newTestRepo()
+	buildRepo = repo
+	now := time.Now()
+	repo.builds = retentionBuilds(now)
+
+	req, _ := http.NewRequest("GET", "/retention/dryrun", nil)
+	recorder := httptest.NewRecorder()
+	router().ServeHTTP(recorder, req)
+
+	var candidates []*models.PruneCandidate
+	json.NewDecoder(recorder.Body).Decode(&candidates)
+	if recorder.Code != http.StatusOK || len(candidates) != 2 {
+		t.Fatalf("Expected 2 builds to prune, Got %v: %s", recorder.Code, recorder.Body)
+	}
+	if len(repo.builds) != 8 {
+		t.Fatalf("Expected a dry run not to prune, Got %d builds", len(repo.builds))
+	}
+
+	n, err := pruneBuilds(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(repo.builds) != 6 {
+		t.Errorf("Expected 2 builds to be pruned, Got %d leaving %d", n, len(repo.builds))
+	}
+	for _, e := range repo.audit {
+		if e.Action != models.AuditPrune || e.Actor != prunerActor || e.Before == nil {
+			t.Errorf("Expected the build pruned to be audited, Got %+v", e)
+		}
+	}
+}
+
+func TestFindPrunableBuildsInPages(t *testing.T) {
+	defer func(size int) { retentionPolicies, retentionPageSize = nil, size }(retentionPageSize)
+	retentionPolicies = RetentionPolicies{{Prefix: "com.HailoOSS.", KeepLast: 1}}
+
+	repo := newTestRepo()
+	buildRepo = repo
+	now := time.Now()
+	for i := 0; i < 7; i++ {
+		b := validBuild()
+		b.Version = fmt.Sprintf("2015010100000%d", 6-i)
+		b.TimeStamp = now.AddDate(0, 0, -i/2).Unix() // Pairs of builds share a timestamp
+		repo.builds = append(repo.builds, &b)
+	}
+
+	for _, size := range []int{1, 2, 3, 1000} {
+		retentionPageSize = size
+		candidates, err := findPrunableBuilds(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 6 {
+			t.Errorf("Expected 6 builds to prune, Got %d (%d)", len(candidates), size)
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, c := range candidates {
+			if seen[c.Version] || c.Version == "20150101000006" {
+				t.Errorf("Expected each older build once, Got %s (%d)", c.Version, size)
+			}
+			seen[c.Version] = true
+		}
+	}
+}
+
+func TestPrunableBuildsAuthorization(t *testing.T) {
+	defer func() { requireTokens = false }()
+	requireTokens = true
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	admin := addTestToken(t, repo, []string{actionAdmin}, nil)
+	reader := addTestToken(t, repo, []string{actionRead}, nil)
+
+	testCases := []struct {
+		path           string
+		token          string
+		expectedStatus int
+	}{
+		{"/retention/dryrun", reader, http.StatusForbidden},
+		{"/retention/dryrunx", reader, http.StatusForbidden},
+		{"/retention/dryrun", "", http.StatusUnauthorized},
+		{"/retention/dryrun", admin, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+}
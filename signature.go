@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerSignature          = "X-Signature"
+	headerSignatureTimestamp = "X-Signature-Timestamp"
+	headerPublisher          = "X-Publisher"
+
+	signaturePrefix        = "sha256="
+	defaultSignatureMaxAge = 5 * time.Minute
+)
+
+var (
+	errUnsigned        = errors.New("The request must be signed")
+	errUnknownSigner   = errors.New("Unknown publisher")
+	errBadTimestamp    = errors.New("Invalid signature timestamp")
+	errStaleSignature  = errors.New("The signature has expired")
+	errBadSignature    = errors.New("Invalid signature")
+	errReplayedRequest = errors.New("The request has already been received")
+)
+
+// loadPublisherSecrets reads a JSON object of the shared secrets of each
+// publisher allowed to create builds
+func loadPublisherSecrets(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	secrets := make(map[string]string)
+	if err := json.NewDecoder(f).Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("Error decoding publisher secrets: %v", err)
+	}
+
+	for publisher, secret := range secrets {
+		if secret == "" {
+			return nil, fmt.Errorf("Blank secret for publisher %q", publisher)
+		}
+	}
+
+	return secrets, nil
+}
+
+// signBody returns the signature of a body sent at a time, as the hex
+// HMAC-SHA256 of the timestamp, a dot and the body
+func signBody(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// replayCache remembers the signatures seen within the maximum age, so that
+// a captured request can't be sent again before it expires
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]int64 // Signature => timestamp
+}
+
+// add records a signature, returning false if it was already seen. Expired
+// signatures are forgotten.
+func (c *replayCache) add(signature string, timestamp, oldest int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen == nil {
+		c.seen = make(map[string]int64)
+	}
+	for sig, ts := range c.seen {
+		if ts < oldest {
+			delete(c.seen, sig)
+		}
+	}
+
+	if _, ok := c.seen[signature]; ok {
+		return false
+	}
+	c.seen[signature] = timestamp
+	return true
+}
+
+// remove forgets a signature, so that a request which wasn't accepted can be
+// sent again
+func (c *replayCache) remove(signature string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.seen, signature)
+}
+
+var signatureReplays = new(replayCache)
+
+// verifySignature checks that a request body was signed by a known publisher
+// within maxAge of now, and hasn't been seen before. The signature is
+// returned so that it can be removed from signatureReplays if the request
+// isn't accepted.
+func verifySignature(r *http.Request, body []byte, secrets map[string]string, maxAge time.Duration, now time.Time) (string, error) {
+	signature := r.Header.Get(headerSignature)
+	if signature == "" {
+		return "", errUnsigned
+	}
+
+	secret, ok := secrets[r.Header.Get(headerPublisher)]
+	if !ok {
+		return "", errUnknownSigner
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(headerSignatureTimestamp), 10, 64)
+	if err != nil {
+		return "", errBadTimestamp
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > maxAge || age < -maxAge {
+		return "", errStaleSignature
+	}
+
+	expected := signaturePrefix + signBody(secret, timestamp, body)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return "", errBadSignature
+	}
+
+	if !signatureReplays.add(expected, timestamp, now.Add(-maxAge).Unix()) {
+		return "", errReplayedRequest
+	}
+
+	return expected, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignedBuilds(t *testing.T) {
+	defer func() { publisherSecrets = nil }()
+	publisherSecrets = map[string]string{"jenkins": "s3cr3t"}
+	signatureMaxAge = defaultSignatureMaxAge
+	signatureReplays = new(replayCache)
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	now := time.Now().Unix()
+	build, _ := json.Marshal(validBuild())
+	signed := "sha256=" + signBody("s3cr3t", now, build)
+
+	testCases := []struct {
+		publisher      string
+		timestamp      int64
+		signature      string
+		expectedStatus int
+	}{
+		{"", 0, "", http.StatusUnauthorized},
+		{"travis", now, signed, http.StatusUnauthorized},
+		{"jenkins", now, "sha256=" + signBody("guess", now, build), http.StatusUnauthorized},
+		{"jenkins", now - 3600, "sha256=" + signBody("s3cr3t", now-3600, build), http.StatusUnauthorized},
+		{"jenkins", now + 1, signed, http.StatusUnauthorized},
+		{"jenkins", now, signed, http.StatusOK},
+		{"jenkins", now, signed, http.StatusUnauthorized},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(build))
+		if tc.publisher != "" {
+			req.Header.Set(headerPublisher, tc.publisher)
+		}
+		if tc.timestamp != 0 {
+			req.Header.Set(headerSignatureTimestamp, strconv.FormatInt(tc.timestamp, 10))
+		}
+		if tc.signature != "" {
+			req.Header.Set(headerSignature, tc.signature)
+		}
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+
+	// A rejected build doesn't spend its signature, so it can be sent again
+	invalid := validBuild()
+	invalid.Version = "20130627091747"
+	invalid.Hostname = ""
+	body, _ := json.Marshal(invalid)
+	for i, expectedStatus := range []int{http.StatusUnprocessableEntity, http.StatusUnprocessableEntity} {
+		req, _ := http.NewRequest("POST", "/builds", bytes.NewReader(body))
+		req.Header.Set(headerPublisher, "jenkins")
+		req.Header.Set(headerSignatureTimestamp, strconv.FormatInt(now, 10))
+		req.Header.Set(headerSignature, "sha256="+signBody("s3cr3t", now, body))
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+
+	if len(repo.audit) != 1 || repo.audit[0].Actor != "publisher jenkins" {
+		t.Fatalf("Expected the build to be created by publisher jenkins, Got %+v", repo.audit)
+	}
+
+	// Anyone can send the headers, so they don't name the actor unless verified
+	req, _ := http.NewRequest("DELETE", "/builds/com.HailoOSS.kernel.build-service/20130627091746", nil)
+	req.Header.Set(headerPublisher, "jenkins")
+	req.Header.Set(headerSignature, signed)
+	recorder := httptest.NewRecorder()
+	router().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v, Got %v: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	if len(repo.audit) != 2 || repo.audit[1].Actor != anonymousActor {
+		t.Errorf("Expected the build to be deleted by %s, Got %+v", anonymousActor, repo.audit)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"sort"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -12,26 +14,71 @@ import (
 	"github.com/HailoOSS/build-service/models"
 )
 
+const (
+	// selectBuilds and joinBuilds surround a table of builds to fetch them
+	// along with their coverage, as read by buildFromRow. Dependencies and
+	// artifacts are fetched separately, as joining them too would return
+	// every combination of package, dependency and artifact.
+	selectBuilds = "SELECT b.hostname,b.architecture,b.goversion,b.sourceurl,b.binaryurl,b.binarysha256,b.binarysize,b.version,b.language,b.name,b.branch,b.timestamp,b.status,b.startedat,b.finishedat,b.failurereason,b.deletedat,b.deletedby,b.deletereason,b.verifiedat,b.verifyresult,b.verifydetail,b.coveragegate,c.package,c.percentage,c.covered,c.statements FROM "
+	joinBuilds   = " b LEFT JOIN coverage c ON b.name = c.service AND b.version = c.version"
+)
+
 type sqlRepo struct {
 	db     *sql.DB
 	dbName string
 
-	getAll           *sql.Stmt
-	getAllWithName   *sql.Stmt
-	getVersion       *sql.Stmt
-	deleteVersion    *sql.Stmt
+	// migrate makes Connect create missing tables, columns and indexes
+	// rather than only checking for them
+	migrate bool
+
+	getAll         *sql.Stmt
+	getAllWithName *sql.Stmt
+	getOlder       *sql.Stmt
+	getVersion     *sql.Stmt
+	getLatest      *sql.Stmt
+	getPrevious    *sql.Stmt
+	getLatestEach  *sql.Stmt
+	getDeps        *sql.Stmt
+	getArtifacts   *sql.Stmt
+	deleteVersion  *sql.Stmt
+	purgeVersion   *sql.Stmt
+	softDelete     *sql.Stmt
+	restore        *sql.Stmt
+	getPurgeable   *sql.Stmt
+	setStatus      *sql.Stmt
+	setBinary      *sql.Stmt
+	setVerified    *sql.Stmt
+	setArtifact    *sql.Stmt
+
+	createToken      *sql.Stmt
+	getToken         *sql.Stmt
+	getTokens        *sql.Stmt
+	revokeToken      *sql.Stmt
+	addAuditEvent    *sql.Stmt
+	getAuditEvents   *sql.Stmt
 	getNames         *sql.Stmt
 	getCoverage      *sql.Stmt
 	getCoverageTrend *sql.Stmt
+	getFileCoverage  *sql.Stmt
+	getFuncCoverage  *sql.Stmt
 
 	createBuild      *sql.Stmt
 	addCoverage      *sql.Stmt
+	addFileCoverage  *sql.Stmt
+	addFuncCoverage  *sql.Stmt
 	addDependency    *sql.Stmt
 	setMergeBaseDate *sql.Stmt
+
+	deleteCoverage     *sql.Stmt
+	deleteFileCoverage *sql.Stmt
+	deleteFuncCoverage *sql.Stmt
+	deleteDependencies *sql.Stmt
+	deleteArtifacts    *sql.Stmt
 }
 
 // Connect and check that the connection was succesful
-// Also prepares the statements
+// Also checks the tables are up to date, or migrates them if r.migrate is
+// set, and prepares the statements
 func (r *sqlRepo) Connect(server, port, username, password, dbName string) error {
 	var err error
 
@@ -52,6 +99,19 @@ func (r *sqlRepo) Connect(server, port, username, password, dbName string) error
 
 	r.dbName = dbName
 
+	if r.migrate {
+		log.Println("Migrating DB")
+		if err := r.Migrate(); err != nil {
+			return fmt.Errorf("Error migrating DB: %v", err)
+		}
+	} else {
+		log.Println("Checking DB schema")
+		if err := r.CheckSchema(); err != nil {
+			return err
+		}
+	}
+	log.Println("OK")
+
 	// Prepare statements
 	err = r.prepareStatements()
 	if err != nil {
@@ -62,32 +122,104 @@ func (r *sqlRepo) Connect(server, port, username, password, dbName string) error
 }
 
 func (r *sqlRepo) prepareStatements() (err error) {
-	if r.getAll, err = r.db.Prepare("SELECT b.hostname,b.architecture,b.goversion,b.sourceurl,b.binaryurl,b.version,b.language,b.name,b.branch,b.timestamp,c.package,c.percentage,d.importpath,d.commit,d.mergebasedate FROM (SELECT * FROM builds ORDER BY timestamp DESC LIMIT ?) b LEFT JOIN coverage c ON b.name = c.service AND b.version = c.version LEFT JOIN dependencies d ON b.name = d.service AND b.version = d.version"); err != nil {
+	if r.getAll, err = r.db.Prepare(selectBuilds + "(SELECT * FROM builds WHERE (?='' OR status=?) AND (? OR deletedat=0) ORDER BY timestamp DESC LIMIT ?)" + joinBuilds); err != nil {
+		return err
+	}
+	if r.getAllWithName, err = r.db.Prepare(selectBuilds + "(SELECT * FROM builds WHERE name=? AND (?='' OR branch=?) AND (?='' OR status=?) AND (? OR deletedat=0) ORDER BY timestamp DESC LIMIT ?)" + joinBuilds); err != nil {
+		return err
+	}
+	if r.getOlder, err = r.db.Prepare(selectBuilds + "(SELECT * FROM builds WHERE name=? AND deletedat=0 AND (?='' OR timestamp<? OR (timestamp=? AND version<?)) ORDER BY timestamp DESC, version DESC LIMIT ?)" + joinBuilds); err != nil {
+		return err
+	}
+	if r.getVersion, err = r.db.Prepare(selectBuilds + "builds" + joinBuilds + " WHERE b.name=? AND b.version=? ORDER BY b.timestamp DESC"); err != nil {
 		return err
 	}
-	if r.getAllWithName, err = r.db.Prepare("SELECT b.hostname,b.architecture,b.goversion,b.sourceurl,b.binaryurl,b.version,b.language,b.name,b.branch,b.timestamp,c.package,c.percentage,d.importpath,d.commit,d.mergebasedate FROM (SELECT * FROM builds WHERE name=? ORDER BY timestamp DESC LIMIT ?) b LEFT JOIN coverage c ON b.name = c.service AND b.version = c.version LEFT JOIN dependencies d ON b.name = d.service AND b.version = d.version"); err != nil {
+	if r.getLatest, err = r.db.Prepare(selectBuilds + "(SELECT * FROM builds WHERE name=? AND (?='' OR branch=?) AND status='succeeded' AND deletedat=0 ORDER BY timestamp DESC LIMIT 1)" + joinBuilds); err != nil {
 		return err
 	}
-	if r.getVersion, err = r.db.Prepare("SELECT b.hostname,b.architecture,b.goversion,b.sourceurl,b.binaryurl,b.version,b.language,b.name,b.branch,b.timestamp,c.package,c.percentage,d.importpath,d.commit,d.mergebasedate FROM builds b LEFT JOIN coverage c ON b.name = c.service AND b.version = c.version LEFT JOIN dependencies d ON b.name = d.service AND b.version = d.version WHERE b.name=? AND b.version=? ORDER BY b.timestamp DESC"); err != nil {
+	if r.getPrevious, err = r.db.Prepare(selectBuilds + "(SELECT * FROM builds WHERE name=? AND (?='' OR branch=?) AND status='succeeded' AND deletedat=0 AND timestamp<? ORDER BY timestamp DESC LIMIT 1)" + joinBuilds); err != nil {
+		return err
+	}
+	if r.getLatestEach, err = r.db.Prepare(selectBuilds + "(SELECT l.* FROM builds l JOIN (SELECT name,branch,MAX(timestamp) AS timestamp FROM builds WHERE name LIKE ? AND (?='' OR branch=?) AND status='succeeded' AND deletedat=0 AND (?=0 OR timestamp<?) GROUP BY name,branch) m ON l.name=m.name AND l.branch=m.branch AND l.timestamp=m.timestamp WHERE l.status='succeeded' AND l.deletedat=0)" + joinBuilds); err != nil {
+		return err
+	}
+	if r.getDeps, err = r.db.Prepare("SELECT importpath,commit,mergebasedate FROM dependencies WHERE service=? AND version=?"); err != nil {
+		return err
+	}
+	if r.getArtifacts, err = r.db.Prepare("SELECT os,architecture,kind,url,sha256,size FROM artifacts WHERE service=? AND version=?"); err != nil {
 		return err
 	}
 	if r.deleteVersion, err = r.db.Prepare("DELETE FROM builds WHERE name=? AND version=?"); err != nil {
 		return err
 	}
-	if r.getNames, err = r.db.Prepare("SELECT DISTINCT name FROM builds WHERE name LIKE ? ORDER BY name ASC"); err != nil {
+	if r.purgeVersion, err = r.db.Prepare("DELETE FROM builds WHERE name=? AND version=? AND deletedat>0"); err != nil {
+		return err
+	}
+	if r.softDelete, err = r.db.Prepare("UPDATE builds SET deletedat=?,deletedby=?,deletereason=? WHERE name=? AND version=? AND deletedat=0"); err != nil {
+		return err
+	}
+	if r.restore, err = r.db.Prepare("UPDATE builds SET deletedat=0,deletedby='',deletereason='' WHERE name=? AND version=? AND deletedat>0"); err != nil {
+		return err
+	}
+	if r.getPurgeable, err = r.db.Prepare("SELECT name,version FROM builds WHERE deletedat>0 AND deletedat<?"); err != nil {
+		return err
+	}
+	if r.setStatus, err = r.db.Prepare("UPDATE builds SET status=?,startedat=?,finishedat=?,failurereason=?,binaryurl=?,binarysha256=?,binarysize=? WHERE name=? AND version=? AND status=?"); err != nil {
+		return err
+	}
+	if r.setBinary, err = r.db.Prepare("UPDATE builds SET binaryurl=?,binarysha256=?,binarysize=? WHERE name=? AND version=?"); err != nil {
+		return err
+	}
+	if r.setArtifact, err = r.db.Prepare("INSERT INTO artifacts (service,version,os,architecture,kind,url,sha256,size) VALUES (?,?,?,?,?,?,?,?) ON DUPLICATE KEY UPDATE url=VALUES(url),sha256=VALUES(sha256),size=VALUES(size)"); err != nil {
+		return err
+	}
+	if r.setVerified, err = r.db.Prepare("UPDATE builds SET verifiedat=?,verifyresult=?,verifydetail=? WHERE name=? AND version=?"); err != nil {
+		return err
+	}
+	if r.createToken, err = r.db.Prepare("INSERT INTO api_tokens (id,name,hash,scopes,prefixes,createdat,createdby) VALUES (?,?,?,?,?,?,?)"); err != nil {
+		return err
+	}
+	if r.getToken, err = r.db.Prepare("SELECT id,name,scopes,prefixes,createdat,createdby,revokedat FROM api_tokens WHERE hash=?"); err != nil {
+		return err
+	}
+	if r.getTokens, err = r.db.Prepare("SELECT id,name,scopes,prefixes,createdat,createdby,revokedat FROM api_tokens ORDER BY createdat DESC"); err != nil {
+		return err
+	}
+	if r.revokeToken, err = r.db.Prepare("UPDATE api_tokens SET revokedat=? WHERE id=? AND revokedat=0"); err != nil {
+		return err
+	}
+	if r.addAuditEvent, err = r.db.Prepare("INSERT INTO audit_log (time,actor,action,service,version,remoteaddr,requestid,clientrequestid,beforestate) VALUES (?,?,?,?,?,?,?,?,?)"); err != nil {
+		return err
+	}
+	if r.getAuditEvents, err = r.db.Prepare("SELECT id,time,actor,action,service,version,remoteaddr,requestid,clientrequestid,beforestate FROM audit_log WHERE (?='' OR actor=?) AND (?='' OR action=?) AND (?='' OR service=?) AND (?='' OR version=?) AND time>=? AND (?=0 OR time<=?) ORDER BY id DESC LIMIT ?"); err != nil {
+		return err
+	}
+	if r.getNames, err = r.db.Prepare("SELECT DISTINCT name FROM builds WHERE name LIKE ? AND deletedat=0 ORDER BY name ASC"); err != nil {
 		return err
 	}
 	if r.getCoverage, err = r.db.Prepare("SELECT package, ROUND(percentage,2) FROM coverage WHERE service=? AND version=? ORDER BY package ASC"); err != nil {
 		return err
 	}
-	if r.getCoverageTrend, err = r.db.Prepare("SELECT c.service, c.version, b.branch, c.package, ROUND(c.percentage,2), b.timestamp FROM coverage c LEFT JOIN builds b ON b.name = c.service AND b.version = c.version WHERE c.service=? AND timestamp>? ORDER BY b.timestamp ASC, c.package ASC"); err != nil {
+	if r.getCoverageTrend, err = r.db.Prepare("SELECT c.service, c.version, b.branch, c.package, ROUND(c.percentage,2), c.covered, c.statements, b.timestamp FROM coverage c LEFT JOIN builds b ON b.name = c.service AND b.version = c.version WHERE c.service=? AND b.deletedat=0 AND b.timestamp>? AND b.timestamp<=? AND (?='' OR b.branch=?) ORDER BY b.timestamp ASC, c.package ASC"); err != nil {
+		return err
+	}
+	if r.getFileCoverage, err = r.db.Prepare("SELECT file, covered, statements, ROUND(percentage,2) FROM file_coverage WHERE service=? AND version=? ORDER BY percentage ASC, statements DESC, file ASC LIMIT ?"); err != nil {
+		return err
+	}
+	if r.getFuncCoverage, err = r.db.Prepare("SELECT file, line, function, ROUND(percentage,2) FROM function_coverage WHERE service=? AND version=? ORDER BY percentage ASC, file ASC, line ASC LIMIT ?"); err != nil {
 		return err
 	}
 
-	if r.createBuild, err = r.db.Prepare("INSERT INTO builds (hostname,architecture,goversion,sourceurl,binaryurl,version,language,name,branch,timestamp) VALUES (?,?,?,?,?,?,?,?,?,?)"); err != nil {
+	if r.createBuild, err = r.db.Prepare("INSERT INTO builds (hostname,architecture,goversion,sourceurl,binaryurl,binarysha256,binarysize,version,language,name,branch,timestamp,status,startedat,finishedat,failurereason,coveragegate) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"); err != nil {
+		return err
+	}
+	if r.addCoverage, err = r.db.Prepare("INSERT INTO coverage (service,version,package,percentage,covered,statements) VALUES (?,?,?,?,?,?)"); err != nil {
 		return err
 	}
-	if r.addCoverage, err = r.db.Prepare("INSERT INTO coverage (service,version,package,percentage) VALUES (?,?,?,?)"); err != nil {
+	if r.addFileCoverage, err = r.db.Prepare("INSERT INTO file_coverage (service,version,file,covered,statements,percentage) VALUES (?,?,?,?,?,?)"); err != nil {
+		return err
+	}
+	if r.addFuncCoverage, err = r.db.Prepare("INSERT INTO function_coverage (service,version,file,line,function,percentage) VALUES (?,?,?,?,?,?)"); err != nil {
 		return err
 	}
 	if r.addDependency, err = r.db.Prepare("INSERT INTO dependencies (service,version,importpath,commit) VALUES (?,?,?,?)"); err != nil {
@@ -96,6 +228,22 @@ func (r *sqlRepo) prepareStatements() (err error) {
 	if r.setMergeBaseDate, err = r.db.Prepare("UPDATE dependencies SET mergebasedate=? WHERE service=? AND version=? AND importpath=? AND commit=?"); err != nil {
 		return err
 	}
+
+	if r.deleteCoverage, err = r.db.Prepare("DELETE FROM coverage WHERE service=? AND version=?"); err != nil {
+		return err
+	}
+	if r.deleteFileCoverage, err = r.db.Prepare("DELETE FROM file_coverage WHERE service=? AND version=?"); err != nil {
+		return err
+	}
+	if r.deleteFuncCoverage, err = r.db.Prepare("DELETE FROM function_coverage WHERE service=? AND version=?"); err != nil {
+		return err
+	}
+	if r.deleteDependencies, err = r.db.Prepare("DELETE FROM dependencies WHERE service=? AND version=?"); err != nil {
+		return err
+	}
+	if r.deleteArtifacts, err = r.db.Prepare("DELETE FROM artifacts WHERE service=? AND version=?"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -108,14 +256,29 @@ func (r *sqlRepo) CreateTables() error {
 		  goversion varchar(255) DEFAULT NULL,
 		  sourceurl varchar(255) NOT NULL DEFAULT '',
 		  binaryurl varchar(255) NOT NULL DEFAULT '',
+		  binarysha256 char(64) NOT NULL DEFAULT '',
+		  binarysize bigint(20) unsigned NOT NULL DEFAULT 0,
 		  version varchar(32) NOT NULL DEFAULT '',
 		  language varchar(127) NOT NULL DEFAULT '',
 		  name varchar(255) NOT NULL DEFAULT '',
 		  branch varchar(255) DEFAULT NULL,
 		  timestamp bigint(20) unsigned NOT NULL,
+		  status varchar(16) NOT NULL DEFAULT 'succeeded',
+		  startedat bigint(20) unsigned NOT NULL DEFAULT 0,
+		  finishedat bigint(20) unsigned NOT NULL DEFAULT 0,
+		  failurereason varchar(1024) NOT NULL DEFAULT '',
+		  deletedat bigint(20) unsigned NOT NULL DEFAULT 0,
+		  deletedby varchar(255) NOT NULL DEFAULT '',
+		  deletereason varchar(1024) NOT NULL DEFAULT '',
+		  verifiedat bigint(20) unsigned NOT NULL DEFAULT 0,
+		  verifyresult varchar(16) NOT NULL DEFAULT '',
+		  verifydetail varchar(1024) NOT NULL DEFAULT '',
+		  coveragegate text,
 		  PRIMARY KEY (id),
 		  INDEX idx_name_version (name,version),
-		  INDEX idx_timestamp (timestamp)
+		  INDEX idx_timestamp (timestamp),
+		  INDEX idx_status_timestamp (status,timestamp),
+		  INDEX idx_deletedat (deletedat)
 		) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
 	`); err != nil {
 		return err
@@ -127,12 +290,97 @@ func (r *sqlRepo) CreateTables() error {
 		  version varchar(32) NOT NULL DEFAULT '',
 		  package varchar(255) NOT NULL DEFAULT '',
 		  percentage float(5,2) unsigned NOT NULL DEFAULT 000.00,
+		  covered int(11) unsigned NOT NULL DEFAULT 0,
+		  statements int(11) unsigned NOT NULL DEFAULT 0,
 		  PRIMARY KEY (service,version,package)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 	`); err != nil {
 		return err
 	}
 
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS file_coverage (
+		  service varchar(255) NOT NULL DEFAULT '',
+		  version varchar(32) NOT NULL DEFAULT '',
+		  file varchar(255) NOT NULL DEFAULT '',
+		  covered int(11) unsigned NOT NULL DEFAULT 0,
+		  statements int(11) unsigned NOT NULL DEFAULT 0,
+		  percentage float(5,2) unsigned NOT NULL DEFAULT 000.00,
+		  PRIMARY KEY (service,version,file)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS function_coverage (
+		  service varchar(255) NOT NULL DEFAULT '',
+		  version varchar(32) NOT NULL DEFAULT '',
+		  file varchar(255) NOT NULL DEFAULT '',
+		  line int(11) unsigned NOT NULL DEFAULT 0,
+		  function varchar(255) NOT NULL DEFAULT '',
+		  percentage float(5,2) unsigned NOT NULL DEFAULT 000.00,
+		  PRIMARY KEY (service,version,file,line,function)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+		  id varchar(32) NOT NULL,
+		  name varchar(255) NOT NULL DEFAULT '',
+		  hash char(64) NOT NULL,
+		  scopes varchar(255) NOT NULL DEFAULT '',
+		  prefixes text,
+		  createdat bigint(20) unsigned NOT NULL,
+		  createdby varchar(255) NOT NULL DEFAULT '',
+		  revokedat bigint(20) unsigned NOT NULL DEFAULT 0,
+		  PRIMARY KEY (id),
+		  UNIQUE INDEX idx_hash (hash)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`); err != nil {
+		return err
+	}
+
+	// The audit log isn't joined to builds, so it's kept when they're deleted
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS audit_log (
+		  id bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+		  time bigint(20) unsigned NOT NULL,
+		  actor varchar(255) NOT NULL DEFAULT '',
+		  action varchar(32) NOT NULL DEFAULT '',
+		  service varchar(255) NOT NULL DEFAULT '',
+		  version varchar(32) NOT NULL DEFAULT '',
+		  remoteaddr varchar(64) NOT NULL DEFAULT '',
+		  requestid varchar(64) NOT NULL DEFAULT '',
+		  clientrequestid varchar(64) NOT NULL DEFAULT '',
+		  beforestate mediumtext,
+		  PRIMARY KEY (id),
+		  INDEX idx_service_version (service,version),
+		  INDEX idx_actor_time (actor,time),
+		  INDEX idx_time (time)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`); err != nil {
+		return err
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS artifacts (
+		  service varchar(255) NOT NULL DEFAULT '',
+		  version varchar(32) NOT NULL DEFAULT '',
+		  os varchar(32) NOT NULL DEFAULT '',
+		  architecture varchar(10) NOT NULL DEFAULT '',
+		  kind varchar(16) NOT NULL DEFAULT '',
+		  url varchar(255) NOT NULL DEFAULT '',
+		  sha256 char(64) NOT NULL DEFAULT '',
+		  size bigint(20) NOT NULL DEFAULT 0,
+		  PRIMARY KEY (service,version,os,architecture,kind)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+	`); err != nil {
+		return err
+	}
+
 	_, err := r.db.Exec(`
 		CREATE TABLE IF NOT EXISTS dependencies (
 		  service varchar(255) NOT NULL DEFAULT '',
@@ -142,37 +390,195 @@ func (r *sqlRepo) CreateTables() error {
 		  mergebasedate bigint(20) unsigned,
 		  PRIMARY KEY (service,version,importpath),
 		  INDEX idx_importpath_commit (importpath,commit)
-		  INDEX idx_importpath_timestamp (importpath,timestamp)
 		) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 	`)
 
 	return err
 }
 
+// columnMigrations are the columns added to tables since they were first
+// created, which CREATE TABLE IF NOT EXISTS doesn't add to existing tables
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	// Statement counts for statement-weighted coverage
+	{"coverage", "covered", "int(11) unsigned NOT NULL DEFAULT 0"},
+	{"coverage", "statements", "int(11) unsigned NOT NULL DEFAULT 0"},
+
+	// Coverage quality gates
+	{"builds", "coveragegate", "text"},
+
+	// Build status and failed builds
+	{"builds", "status", "varchar(16) NOT NULL DEFAULT 'succeeded'"},
+	{"builds", "startedat", "bigint(20) unsigned NOT NULL DEFAULT 0"},
+	{"builds", "finishedat", "bigint(20) unsigned NOT NULL DEFAULT 0"},
+	{"builds", "failurereason", "varchar(1024) NOT NULL DEFAULT ''"},
+
+	// Audit log
+	{"audit_log", "clientrequestid", "varchar(64) NOT NULL DEFAULT ''"},
+
+	// Soft delete
+	{"builds", "deletedat", "bigint(20) unsigned NOT NULL DEFAULT 0"},
+	{"builds", "deletedby", "varchar(255) NOT NULL DEFAULT ''"},
+	{"builds", "deletereason", "varchar(1024) NOT NULL DEFAULT ''"},
+
+	// Artifact checksums and verification
+	{"builds", "binarysha256", "char(64) NOT NULL DEFAULT ''"},
+	{"builds", "binarysize", "bigint(20) unsigned NOT NULL DEFAULT 0"},
+	{"builds", "verifiedat", "bigint(20) unsigned NOT NULL DEFAULT 0"},
+	{"builds", "verifyresult", "varchar(16) NOT NULL DEFAULT ''"},
+	{"builds", "verifydetail", "varchar(1024) NOT NULL DEFAULT ''"},
+}
+
+// indexMigrations are the indexes added to tables since they were first
+// created
+var indexMigrations = []struct {
+	table, index, columns string
+}{
+	// Build status and failed builds
+	{"builds", "idx_status_timestamp", "status,timestamp"},
+
+	// Soft delete
+	{"builds", "idx_deletedat", "deletedat"},
+}
+
+func (r *sqlRepo) hasColumn(table, column string) (bool, error) {
+	var n int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM information_schema.columns WHERE table_schema=? AND table_name=? AND column_name=?", r.dbName, table, column).Scan(&n)
+	return n > 0, err
+}
+
+func (r *sqlRepo) hasIndex(table, index string) (bool, error) {
+	var n int
+	err := r.db.QueryRow("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema=? AND table_name=? AND index_name=?", r.dbName, table, index).Scan(&n)
+	return n > 0, err
+}
+
+// CheckSchema returns an error naming any columns or indexes missing from the
+// tables, which need -createtables to add them. It doesn't change the schema.
+func (r *sqlRepo) CheckSchema() error {
+	var missing []string
+	for _, m := range columnMigrations {
+		ok, err := r.hasColumn(m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, fmt.Sprintf("column %s.%s", m.table, m.column))
+		}
+	}
+	for _, m := range indexMigrations {
+		ok, err := r.hasIndex(m.table, m.index)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, fmt.Sprintf("index %s.%s", m.table, m.index))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("DB schema is out of date, missing %s. Run build-service -createtables to add them", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Migrate creates any missing tables, and adds any missing columns and
+// indexes to existing tables
+func (r *sqlRepo) Migrate() error {
+	if err := r.CreateTables(); err != nil {
+		return err
+	}
+
+	for _, m := range columnMigrations {
+		ok, err := r.hasColumn(m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		log.Printf("Adding column %s.%s", m.table, m.column)
+		if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition)); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range indexMigrations {
+		ok, err := r.hasIndex(m.table, m.index)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		log.Printf("Adding index %s.%s", m.table, m.index)
+		if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD INDEX %s (%s)", m.table, m.index, m.columns)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type rowScanner interface {
 	Scan(dest ...interface{}) error
 }
 
 type buildWithJoins struct {
 	models.Build
-	PackageName   sql.NullString
-	Percentage    sql.NullFloat64
-	ImportPath    sql.NullString
-	Commit        sql.NullString
-	MergeBaseDate sql.NullInt64
+	DeletedAt    int64
+	DeletedBy    string
+	DeleteReason string
+	VerifiedAt   int64
+	VerifyResult string
+	VerifyDetail string
+	CoverageGate sql.NullString
+	PackageName  sql.NullString
+	Percentage   sql.NullFloat64
+	Covered      sql.NullInt64
+	Statements   sql.NullInt64
 }
 
 func buildFromRow(rows rowScanner) (*buildWithJoins, error) {
 	b := new(buildWithJoins)
-	err := rows.Scan(&b.Hostname, &b.Architecture, &b.GoVersion, &b.SourceURL, &b.BinaryURL, &b.Version, &b.Language, &b.Name, &b.Branch, &b.TimeStamp, &b.PackageName, &b.Percentage, &b.ImportPath, &b.Commit, &b.MergeBaseDate)
+	err := rows.Scan(&b.Hostname, &b.Architecture, &b.GoVersion, &b.SourceURL, &b.BinaryURL, &b.BinarySHA256, &b.BinarySize, &b.Version, &b.Language, &b.Name, &b.Branch, &b.TimeStamp, &b.Status, &b.StartedAt, &b.FinishedAt, &b.FailureReason, &b.DeletedAt, &b.DeletedBy, &b.DeleteReason, &b.VerifiedAt, &b.VerifyResult, &b.VerifyDetail, &b.CoverageGate, &b.PackageName, &b.Percentage, &b.Covered, &b.Statements)
 	return b, err
 }
 
-func buildsFromQuery(f func() (*sql.Rows, error)) ([]*models.Build, error) {
+// buildsFromQuery reads the builds returned by a query along with their
+// dependencies and artifacts, which are fetched in tx if it isn't nil
+func (r *sqlRepo) buildsFromQuery(tx *sql.Tx, f func() (*sql.Rows, error)) ([]*models.Build, error) {
+	builds, err := buildsWithCoverage(f)
+	if err != nil {
+		return nil, err
+	}
+
+	getDeps, getArtifacts := r.getDeps, r.getArtifacts
+	if tx != nil {
+		getDeps, getArtifacts = tx.Stmt(getDeps), tx.Stmt(getArtifacts)
+	}
+	for _, build := range builds {
+		if err := readDependencies(getDeps, build); err != nil {
+			return nil, err
+		}
+		if err := readArtifacts(getArtifacts, build); err != nil {
+			return nil, err
+		}
+		sort.Sort(byArtifactKey(build.Artifacts))
+	}
+
+	return builds, nil
+}
+
+// buildsWithCoverage reads the builds returned by a query with their
+// coverage alone
+func buildsWithCoverage(f func() (*sql.Rows, error)) ([]*models.Build, error) {
 	rows, err := f()
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	builds := make([]*models.Build, 0)
 	buildByName := map[string]*models.Build{}
@@ -188,72 +594,297 @@ func buildsFromQuery(f func() (*sql.Rows, error)) ([]*models.Build, error) {
 		if !ok {
 			build = &b.Build
 			build.Coverage = map[string]float64{}
+			build.Statements = map[string]models.StatementCount{}
 			build.Dependencies = map[string]string{}
 			build.MergeBaseDates = map[string]time.Time{}
 			buildByName[key] = build
 			builds = append(builds, build)
+
+			if b.DeletedAt > 0 {
+				build.Deleted = &models.Deletion{Time: b.DeletedAt, Actor: b.DeletedBy, Reason: b.DeleteReason}
+			}
+			if b.VerifiedAt > 0 {
+				build.Verification = &models.Verification{Time: b.VerifiedAt, Result: b.VerifyResult, Detail: b.VerifyDetail}
+			}
+
+			if b.CoverageGate.Valid {
+				build.CoverageGate = new(models.CoverageGate)
+				if err := json.Unmarshal([]byte(b.CoverageGate.String), build.CoverageGate); err != nil {
+					return nil, err
+				}
+			}
 		}
 
 		if b.PackageName.Valid {
 			build.Coverage[b.PackageName.String] = b.Percentage.Float64
-		}
-
-		if b.ImportPath.Valid {
-			build.Dependencies[b.ImportPath.String] = b.Commit.String
 
-			if b.MergeBaseDate.Valid {
-				build.MergeBaseDates[b.ImportPath.String] = time.Unix(b.MergeBaseDate.Int64, 0)
+			if b.Statements.Int64 > 0 {
+				build.Statements[b.PackageName.String] = models.StatementCount{
+					Covered: b.Covered.Int64,
+					Total:   b.Statements.Int64,
+				}
 			}
 		}
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, build := range builds {
+		build.TotalCoverage = models.AggregateCoverage(build.Coverages())
+	}
 
 	return builds, nil
 }
 
-func (r *sqlRepo) Create(b *models.Build) error {
-	if _, err := r.createBuild.Exec(
+// readDependencies reads the dependencies of a build
+func readDependencies(stmt *sql.Stmt, build *models.Build) error {
+	rows, err := stmt.Query(build.Name, build.Version)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var importPath, commit string
+		var mergeBaseDate sql.NullInt64
+		if err := rows.Scan(&importPath, &commit, &mergeBaseDate); err != nil {
+			return err
+		}
+
+		build.Dependencies[importPath] = commit
+		if mergeBaseDate.Valid {
+			build.MergeBaseDates[importPath] = time.Unix(mergeBaseDate.Int64, 0)
+		}
+	}
+	return rows.Err()
+}
+
+// readArtifacts reads the artifacts of a build
+func readArtifacts(stmt *sql.Stmt, build *models.Build) error {
+	rows, err := stmt.Query(build.Name, build.Version)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Artifact
+		if err := rows.Scan(&a.OS, &a.Architecture, &a.Kind, &a.URL, &a.SHA256, &a.Size); err != nil {
+			return err
+		}
+		build.Artifacts = append(build.Artifacts, a)
+	}
+	return rows.Err()
+}
+
+type byArtifactKey []models.Artifact
+
+func (a byArtifactKey) Len() int           { return len(a) }
+func (a byArtifactKey) Less(i, j int) bool { return a[i].Key() < a[j].Key() }
+func (a byArtifactKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func (r *sqlRepo) Create(b *models.Build, audit *models.AuditEvent) error {
+	var coverageGate sql.NullString
+	if b.CoverageGate != nil {
+		data, err := json.Marshal(b.CoverageGate)
+		if err != nil {
+			return err
+		}
+		coverageGate = sql.NullString{String: string(data), Valid: true}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	existing, err := r.getVersionTx(tx, b.Name, b.Version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if existing != nil {
+		tx.Rollback()
+		return errBuildExists
+	}
+
+	if _, err := tx.Stmt(r.createBuild).Exec(
 		b.Hostname,
 		b.Architecture,
 		b.GoVersion,
 		b.SourceURL,
 		b.BinaryURL,
+		b.BinarySHA256,
+		b.BinarySize,
 		b.Version,
 		b.Language,
 		b.Name,
 		b.Branch,
 		b.TimeStamp,
+		b.Status,
+		b.StartedAt,
+		b.FinishedAt,
+		b.FailureReason,
+		coverageGate,
 	); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	if b.Coverage != nil {
-		for packageName, coveragePercentage := range b.Coverage {
-			if _, err := r.addCoverage.Exec(b.Name, b.Version, packageName, coveragePercentage); err != nil {
-				return err
-			}
-		}
+	if err := r.addCoverageReport(tx, b.Name, b.Version, &b.CoverageReport); err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	if b.Dependencies != nil {
 		for importPath, commit := range b.Dependencies {
-			if _, err := r.addDependency.Exec(b.Name, b.Version, importPath, commit); err != nil {
+			if _, err := tx.Stmt(r.addDependency).Exec(b.Name, b.Version, importPath, commit); err != nil {
+				tx.Rollback()
 				return err
 			}
 		}
 	}
 
+	if err := r.addArtifacts(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, nil); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// addArtifacts saves the artifacts of a build, replacing any for the same
+// platform and kind
+func (r *sqlRepo) addArtifacts(tx *sql.Tx, b *models.Build) error {
+	for _, a := range b.Artifacts {
+		if _, err := tx.Stmt(r.setArtifact).Exec(b.Name, b.Version, a.OS, a.Architecture, a.Kind, a.URL, a.SHA256, a.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getVersionTx returns a build as seen by a transaction, or nil if there
+// isn't one
+func (r *sqlRepo) getVersionTx(tx *sql.Tx, name, version string) (*models.Build, error) {
+	builds, err := r.buildsFromQuery(tx, func() (*sql.Rows, error) { return tx.Stmt(r.getVersion).Query(name, version) })
+	if len(builds) > 0 {
+		return builds[0], err
+	}
+	return nil, err
+}
+
+// addAuditEventTx records a change to a build in the same transaction as the
+// change, along with the build as it was before
+func (r *sqlRepo) addAuditEventTx(tx *sql.Tx, audit *models.AuditEvent, before *models.Build) error {
+	if audit == nil {
+		return nil
+	}
+
+	var snapshot sql.NullString
+	if before != nil {
+		data, err := json.Marshal(before)
+		if err != nil {
+			return err
+		}
+		snapshot = sql.NullString{String: string(data), Valid: true}
+		audit.Before = before
+	}
+
+	res, err := tx.Stmt(r.addAuditEvent).Exec(audit.Time, audit.Actor, audit.Action, audit.Service, audit.Version, audit.RemoteAddr, audit.RequestID, audit.ClientRequestID, snapshot)
+	if err != nil {
+		return err
+	}
+	audit.ID, err = res.LastInsertId()
+	return err
+}
+
+// addCoverageReport inserts the package, file and function coverage of a build
+func (r *sqlRepo) addCoverageReport(tx *sql.Tx, name, version string, c *models.CoverageReport) error {
+	for packageName, coveragePercentage := range c.Coverage {
+		sc := c.Statements[packageName]
+		if _, err := tx.Stmt(r.addCoverage).Exec(name, version, packageName, coveragePercentage, sc.Covered, sc.Total); err != nil {
+			return err
+		}
+	}
+
+	for _, fc := range c.Files {
+		if _, err := tx.Stmt(r.addFileCoverage).Exec(name, version, fc.FileName, fc.Covered, fc.Statements, fc.Percentage); err != nil {
+			return err
+		}
+	}
+
+	for _, fc := range c.Functions {
+		if _, err := tx.Stmt(r.addFuncCoverage).Exec(name, version, fc.FileName, fc.Line, fc.FunctionName, fc.Percentage); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// SetCoverage replaces all of the coverage of an existing build
+func (r *sqlRepo) SetCoverage(name, version string, c *models.CoverageReport, audit *models.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, stmt := range []*sql.Stmt{r.deleteCoverage, r.deleteFileCoverage, r.deleteFuncCoverage} {
+		if _, err := tx.Stmt(stmt).Exec(name, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := r.addCoverageReport(tx, name, version, c); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (r *sqlRepo) SetMergeBaseDate(service, version, importPath, commit string, date time.Time) error {
 	_, err := r.setMergeBaseDate.Exec(date.Unix(), service, version, importPath, commit)
 	return err
 }
 
 func (r *sqlRepo) GetNames(filter string) ([]string, error) {
+	return namesFromQuery(r.getNames, "%"+filter+"%")
+}
+
+// GetNamesWithPrefix returns the names of the services starting with a prefix
+func (r *sqlRepo) GetNamesWithPrefix(prefix string) ([]string, error) {
+	return namesFromQuery(r.getNames, likePrefix(prefix))
+}
+
+// likePrefix returns a LIKE pattern matching the values starting with prefix
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+func namesFromQuery(stmt *sql.Stmt, pattern string) ([]string, error) {
 	names := make([]string, 0)
 
-	rows, err := r.getNames.Query("%" + filter + "%")
+	rows, err := stmt.Query(pattern)
 	if err != nil {
 		return names, err
 	}
@@ -270,27 +901,381 @@ func (r *sqlRepo) GetNames(filter string) ([]string, error) {
 	return names, nil
 }
 
-func (r *sqlRepo) GetAll(limit int) ([]*models.Build, error) {
-	return buildsFromQuery(func() (*sql.Rows, error) { return r.getAll.Query(limit) })
+func (r *sqlRepo) GetAll(status string, includeDeleted bool, limit int) ([]*models.Build, error) {
+	return r.buildsFromQuery(nil, func() (*sql.Rows, error) { return r.getAll.Query(status, status, includeDeleted, limit) })
 }
 
-func (r *sqlRepo) GetAllWithName(name string, limit int) ([]*models.Build, error) {
-	return buildsFromQuery(func() (*sql.Rows, error) { return r.getAllWithName.Query(name, limit) })
+func (r *sqlRepo) GetAllWithName(name, branch, status string, includeDeleted bool, limit int) ([]*models.Build, error) {
+	return r.buildsFromQuery(nil, func() (*sql.Rows, error) {
+		return r.getAllWithName.Query(name, branch, branch, status, status, includeDeleted, limit)
+	})
+}
+
+// GetOlderWithName returns a page of the builds of a service, newest first,
+// which come after the build with a timestamp and version, or from the newest
+// build if version is blank. Only their coverage is read.
+func (r *sqlRepo) GetOlderWithName(name string, timestamp int64, version string, limit int) ([]*models.Build, error) {
+	return buildsWithCoverage(func() (*sql.Rows, error) {
+		return r.getOlder.Query(name, version, timestamp, timestamp, version, limit)
+	})
 }
 
 func (r *sqlRepo) GetVersion(name, version string) (*models.Build, error) {
-	builds, err := buildsFromQuery(func() (*sql.Rows, error) { return r.getVersion.Query(name, version) })
+	builds, err := r.buildsFromQuery(nil, func() (*sql.Rows, error) { return r.getVersion.Query(name, version) })
+	if len(builds) > 0 {
+		return builds[0], err
+	}
+	return nil, err
+}
+
+func (r *sqlRepo) GetLatest(name, branch string) (*models.Build, error) {
+	builds, err := r.buildsFromQuery(nil, func() (*sql.Rows, error) { return r.getLatest.Query(name, branch, branch) })
 	if len(builds) > 0 {
 		return builds[0], err
 	}
 	return nil, err
 }
 
-func (r *sqlRepo) Delete(name, version string) error {
-	_, err := r.deleteVersion.Exec(name, version)
+// GetLatestBuilds returns the latest successful build of every service with a
+// name prefix on each branch, or only on branch if it isn't blank, from before
+// a UTC unix timestamp unless it's 0. Only their coverage is read.
+func (r *sqlRepo) GetLatestBuilds(prefix, branch string, before int64) ([]*models.Build, error) {
+	return buildsWithCoverage(func() (*sql.Rows, error) {
+		return r.getLatestEach.Query(likePrefix(prefix), branch, branch, before, before)
+	})
+}
+
+// GetLatestBefore returns the latest successful build on a branch from before
+// a UTC unix timestamp
+func (r *sqlRepo) GetLatestBefore(name, branch string, before int64) (*models.Build, error) {
+	builds, err := r.buildsFromQuery(nil, func() (*sql.Rows, error) { return r.getPrevious.Query(name, branch, branch, before) })
+	if len(builds) > 0 {
+		return builds[0], err
+	}
+	return nil, err
+}
+
+// SetStatus saves the status of a build, as long as it hasn't changed from
+// previous in the meantime
+func (r *sqlRepo) SetStatus(b *models.Build, previous string, audit *models.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := r.getVersionTx(tx, b.Name, b.Version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.Stmt(r.setStatus).Exec(b.Status, b.StartedAt, b.FinishedAt, b.FailureReason, b.BinaryURL, b.BinarySHA256, b.BinarySize, b.Name, b.Version, previous)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return err
+	} else if n == 0 {
+		tx.Rollback()
+		return errStatusChanged
+	}
+
+	if err := r.addArtifacts(tx, b); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetBinary changes where the binary of a build is downloaded from, and its
+// checksum and size
+func (r *sqlRepo) SetBinary(name, version, binaryURL, sha256 string, size int64, audit *models.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Stmt(r.setBinary).Exec(binaryURL, sha256, size, name, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetArtifact adds an artifact to a build, replacing any of the same kind for
+// the same platform
+func (r *sqlRepo) SetArtifact(name, version string, a *models.Artifact, audit *models.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Stmt(r.setArtifact).Exec(name, version, a.OS, a.Architecture, a.Kind, a.URL, a.SHA256, a.Size); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetVerification records the result of verifying the binary of a build
+func (r *sqlRepo) SetVerification(name, version string, v *models.Verification) error {
+	_, err := r.setVerified.Exec(v.Time, v.Result, v.Detail, name, version)
 	return err
 }
 
+func (r *sqlRepo) CreateToken(t *models.APIToken) error {
+	_, err := r.createToken.Exec(t.ID, t.Name, t.Hash, strings.Join(t.Scopes, ","), strings.Join(t.Prefixes, ","), t.CreatedAt, t.CreatedBy)
+	return err
+}
+
+func tokenFromRow(rows rowScanner) (*models.APIToken, error) {
+	t := new(models.APIToken)
+	var scopes string
+	var prefixes sql.NullString
+	if err := rows.Scan(&t.ID, &t.Name, &scopes, &prefixes, &t.CreatedAt, &t.CreatedBy, &t.RevokedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = splitList(scopes)
+	t.Prefixes = splitList(prefixes.String)
+	return t, nil
+}
+
+// GetToken returns the token with the hash, or nil if there isn't one
+func (r *sqlRepo) GetToken(hash string) (*models.APIToken, error) {
+	t, err := tokenFromRow(r.getToken.QueryRow(hash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+func (r *sqlRepo) GetTokens() ([]*models.APIToken, error) {
+	rows, err := r.getTokens.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*models.APIToken, 0)
+	for rows.Next() {
+		t, err := tokenFromRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken revokes a valid token, returning whether there was one
+func (r *sqlRepo) RevokeToken(id string, at int64) (bool, error) {
+	res, err := r.revokeToken.Exec(at, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Delete marks a build as deleted, hiding it until it's restored or purged
+func (r *sqlRepo) Delete(name, version string, d *models.Deletion, audit *models.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if before == nil || before.Deleted != nil {
+		return tx.Rollback()
+	}
+
+	if _, err := tx.Stmt(r.softDelete).Exec(d.Time, d.Actor, d.Reason, name, version); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Restore undeletes a deleted build, returning whether there was one
+func (r *sqlRepo) Restore(name, version string, audit *models.AuditEvent) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	res, err := tx.Stmt(r.restore).Exec(name, version)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// Purge permanently deletes the builds deleted before a time, returning them
+// as they were. Each is recorded as a copy of the audit event.
+func (r *sqlRepo) Purge(deletedBefore int64, audit *models.AuditEvent) ([]*models.Build, error) {
+	rows, err := r.getPurgeable.Query(deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	type nameVersion struct{ name, version string }
+	purgeable := make([]nameVersion, 0)
+	for rows.Next() {
+		var nv nameVersion
+		if err := rows.Scan(&nv.name, &nv.version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		purgeable = append(purgeable, nv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	purged := make([]*models.Build, 0, len(purgeable))
+	for _, nv := range purgeable {
+		event := *audit
+		event.Service, event.Version = nv.name, nv.version
+		b, err := r.pruneBuild(r.purgeVersion, nv.name, nv.version, &event)
+		if err != nil {
+			return purged, err
+		}
+		if b != nil {
+			purged = append(purged, b)
+		}
+	}
+	return purged, nil
+}
+
+// Prune permanently deletes a build along with its coverage, dependencies
+// and artifacts, returning it as it was
+func (r *sqlRepo) Prune(name, version string, audit *models.AuditEvent) (*models.Build, error) {
+	return r.pruneBuild(r.deleteVersion, name, version, audit)
+}
+
+// pruneBuild permanently deletes a build with the statement deleting it from
+// builds, returning it as it was, or nil if the statement deleted nothing
+func (r *sqlRepo) pruneBuild(deleteBuild *sql.Stmt, name, version string, audit *models.AuditEvent) (*models.Build, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := r.getVersionTx(tx, name, version)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	res, err := tx.Stmt(deleteBuild).Exec(name, version)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, stmt := range []*sql.Stmt{r.deleteCoverage, r.deleteFileCoverage, r.deleteFuncCoverage, r.deleteDependencies, r.deleteArtifacts} {
+		if _, err := tx.Stmt(stmt).Exec(name, version); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := r.addAuditEventTx(tx, audit, before); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return before, tx.Commit()
+}
+
+func (r *sqlRepo) GetAuditEvents(f *models.AuditFilter) ([]*models.AuditEvent, error) {
+	rows, err := r.getAuditEvents.Query(f.Actor, f.Actor, f.Action, f.Action, f.Service, f.Service, f.Version, f.Version, f.Since, f.Until, f.Until, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*models.AuditEvent, 0)
+	for rows.Next() {
+		e := new(models.AuditEvent)
+		var before sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Action, &e.Service, &e.Version, &e.RemoteAddr, &e.RequestID, &e.ClientRequestID, &before); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = new(models.Build)
+			if err := json.Unmarshal([]byte(before.String), e.Before); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
 func (r *sqlRepo) GetCoverage(name, version string) (map[string]float64, error) {
 	rows, err := r.getCoverage.Query(name, version)
 	if err != nil {
@@ -315,44 +1300,86 @@ func (r *sqlRepo) GetCoverage(name, version string) (map[string]float64, error)
 	return coverage, nil
 }
 
+func (r *sqlRepo) GetFileCoverage(name, version string, limit int) ([]models.FileCoverage, error) {
+	rows, err := r.getFileCoverage.Query(name, version, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]models.FileCoverage, 0)
+	for rows.Next() {
+		fc := models.FileCoverage{}
+		if err = rows.Scan(&fc.FileName, &fc.Covered, &fc.Statements, &fc.Percentage); err != nil {
+			return nil, err
+		}
+		files = append(files, fc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (r *sqlRepo) GetFunctionCoverage(name, version string, limit int) ([]models.FunctionCoverage, error) {
+	rows, err := r.getFuncCoverage.Query(name, version, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]models.FunctionCoverage, 0)
+	for rows.Next() {
+		fc := models.FunctionCoverage{}
+		if err = rows.Scan(&fc.FileName, &fc.Line, &fc.FunctionName, &fc.Percentage); err != nil {
+			return nil, err
+		}
+		functions = append(functions, fc)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return functions, nil
+}
+
 type coverageRow struct {
 	service    string
 	version    string
 	branch     string
 	pkg        string
 	percentage float64
+	covered    int64
+	statements int64
 	timestamp  int64
 }
 
+// groupCoverageRows groups the coverage rows of each build into a snapshot
 func groupCoverageRows(coverageRows []coverageRow) models.CoverageSnapshots {
-	coverageByTimestamp := make(map[int64][]coverageRow)
+	coverageByBuild := make(map[string][]coverageRow)
 	for i, cr := range coverageRows {
-		if coverageByTimestamp[cr.timestamp] == nil {
-			coverageByTimestamp[cr.timestamp] = make([]coverageRow, 0)
-		}
-		coverageByTimestamp[cr.timestamp] = append(coverageByTimestamp[cr.timestamp], coverageRows[i])
+		key := cr.service + "/" + cr.version
+		coverageByBuild[key] = append(coverageByBuild[key], coverageRows[i])
 	}
 
-	snapshots := make(models.CoverageSnapshots, len(coverageByTimestamp))
-	i := 0
-	for ts, cr := range coverageByTimestamp {
+	snapshots := make(models.CoverageSnapshots, 0, len(coverageByBuild))
+	for _, cr := range coverageByBuild {
 		snapshot := models.CoverageSnapshot{
-			Timestamp: ts,
+			Timestamp: cr[0].timestamp,
+			Branch:    cr[0].branch,
+			Version:   cr[0].version,
 			Coverages: make([]models.Coverage, len(cr)),
 		}
 		for j, c := range cr {
-			if j == 0 {
-				snapshot.Branch = c.branch
-				snapshot.Version = c.version
-			}
 			snapshot.Coverages[j] = models.Coverage{
 				PackageName: c.pkg,
 				Percentage:  c.percentage,
+				Covered:     c.covered,
+				Statements:  c.statements,
 			}
 		}
+		snapshot.TotalCoverage = models.AggregateCoverage(snapshot.Coverages)
 
-		snapshots[i] = snapshot
-		i++
+		snapshots = append(snapshots, snapshot)
 	}
 
 	sort.Sort(snapshots)
@@ -360,8 +1387,8 @@ func groupCoverageRows(coverageRows []coverageRow) models.CoverageSnapshots {
 	return snapshots
 }
 
-func (r *sqlRepo) GetCoverageTrend(name string, since time.Time) (models.CoverageSnapshots, error) {
-	rows, err := r.getCoverageTrend.Query(name, since.Unix())
+func (r *sqlRepo) GetCoverageTrend(name, branch string, since, until time.Time) (models.CoverageSnapshots, error) {
+	rows, err := r.getCoverageTrend.Query(name, since.Unix(), until.Unix(), branch, branch)
 	if err != nil {
 		return nil, err
 	}
@@ -369,7 +1396,7 @@ func (r *sqlRepo) GetCoverageTrend(name string, since time.Time) (models.Coverag
 	coverageRows := make([]coverageRow, 0)
 	for rows.Next() {
 		cr := coverageRow{}
-		err = rows.Scan(&cr.service, &cr.version, &cr.branch, &cr.pkg, &cr.percentage, &cr.timestamp)
+		err = rows.Scan(&cr.service, &cr.version, &cr.branch, &cr.pkg, &cr.percentage, &cr.covered, &cr.statements, &cr.timestamp)
 		if err != nil {
 			return nil, err
 		}
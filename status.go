@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+	"github.com/HailoOSS/build-service/validate"
+)
+
+// errStatusChanged is returned by a BuildRepository when the status of a
+// build was changed by someone else before it could be saved
+var errStatusChanged = errors.New("The status of the build has changed")
+
+func validStatus(status string) bool {
+	switch status {
+	case models.StatusQueued, models.StatusRunning, models.StatusSucceeded, models.StatusFailed, models.StatusCancelled:
+		return true
+	}
+	return false
+}
+
+func setStatusHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("PATCH STATUS", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	buildVersion := r.URL.Query().Get(":version")
+
+	if serviceName == "" {
+		logHTTPError(rw, "Missing Service Name", http.StatusBadRequest)
+		return
+	}
+
+	if buildVersion == "" {
+		logHTTPError(rw, "Missing version", http.StatusBadRequest)
+		return
+	}
+
+	if !authorize(rw, r, actionWrite, serviceName) {
+		return
+	}
+
+	if r.Body == nil {
+		logHTTPError(rw, "No PATCH body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	update := new(models.StatusUpdate)
+	if p := decodeJSON(r.Body, update); p != nil {
+		logProblem(rw, p)
+		return
+	}
+
+	if errors := validate.Validate(update); len(errors) > 0 {
+		logProblem(rw, &problem{
+			Title:  "Invalid status",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
+		return
+	}
+
+	if update.Time == 0 {
+		update.Time = time.Now().Unix()
+	}
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	previous := build.Status
+	updated := *build
+	if err := updated.SetStatus(update); err != nil {
+		logProblem(rw, &problem{
+			Title:  "Invalid status change",
+			Status: http.StatusConflict,
+			Detail: err.Error(),
+		})
+		return
+	}
+
+	if errors := ValidateBuild(&updated); len(errors) > 0 {
+		logProblem(rw, &problem{
+			Title:  "Invalid build",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
+		return
+	}
+
+	err = buildRepo.SetStatus(&updated, previous, newAuditEvent(r, models.AuditStatus, serviceName, buildVersion))
+	if err == errStatusChanged {
+		logProblem(rw, &problem{
+			Title:  "Invalid status change",
+			Status: http.StatusConflict,
+			Detail: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error saving status: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(&updated)
+}
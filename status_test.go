@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func TestSetStatus(t *testing.T) {
+	const path = "/builds?:name=com.HailoOSS.kernel.build-service&:version=20130627091746"
+
+	testCases := []struct {
+		from           string
+		body           string
+		expectedStatus int
+		expectedBuild  func(*models.Build) bool
+	}{
+		{models.StatusQueued, `{"Status":"running","Time":100}`, http.StatusOK, func(b *models.Build) bool {
+			return b.Status == models.StatusRunning && b.StartedAt == 100 && b.FinishedAt == 0
+		}},
+		{models.StatusRunning, `{"Status":"failed","FailureReason":"Tests failed","Time":200}`, http.StatusOK, func(b *models.Build) bool {
+			return b.Status == models.StatusFailed && b.FailureReason == "Tests failed" && b.FinishedAt == 200 && b.Duration() == 100
+		}},
+		{models.StatusRunning, `{"Status":"succeeded","BinaryURL":"http://s3.amazon.com/abcdefg","BinarySHA256":"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08","BinarySize":4,"Time":200}`, http.StatusOK, func(b *models.Build) bool {
+			return b.Status == models.StatusSucceeded && b.BinaryURL == "http://s3.amazon.com/abcdefg"
+		}},
+		{models.StatusRunning, `{"Status":"succeeded"}`, http.StatusUnprocessableEntity, nil},
+		{models.StatusRunning, `{"Status":"running","FailureReason":"Too slow"}`, http.StatusConflict, nil},
+		{models.StatusRunning, `{"Status":"exploded"}`, http.StatusUnprocessableEntity, nil},
+		{models.StatusFailed, `{"Status":"running"}`, http.StatusConflict, nil},
+		{models.StatusSucceeded, `{"Status":"cancelled"}`, http.StatusConflict, nil},
+		{models.StatusQueued, `not json`, http.StatusBadRequest, nil},
+	}
+
+	for i, tc := range testCases {
+		b := validBuild()
+		b.Status = tc.from
+		b.BinaryURL = ""
+		b.StartedAt = 100
+		if tc.from == models.StatusQueued {
+			b.StartedAt = 0
+		}
+
+		repo := newTestRepo()
+		repo.builds = append(repo.builds, &b)
+		buildRepo = repo
+
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", path, bytes.NewReader([]byte(tc.body)))
+		setStatusHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+		if tc.expectedBuild == nil {
+			if repo.called == "SetStatus" {
+				t.Errorf("Expected the status not to be saved (%d)", i)
+			}
+			continue
+		}
+
+		updated := new(models.Build)
+		json.NewDecoder(recorder.Body).Decode(updated)
+		if !tc.expectedBuild(updated) || !tc.expectedBuild(repo.builds[0]) {
+			t.Errorf("Unexpected build %+v (%d)", updated, i)
+		}
+	}
+}
+
+func TestGetBuildsByStatus(t *testing.T) {
+	testCases := []struct {
+		reqPath        string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{"/builds", http.StatusOK, 2},
+		{"/builds?status=failed", http.StatusOK, 1},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&status=succeeded", http.StatusOK, 1},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&status=queued", http.StatusOK, 0},
+		{"/builds?status=exploded", http.StatusBadRequest, 0},
+	}
+
+	for i, tc := range testCases {
+		succeeded := validBuild()
+		failed := validBuild()
+		failed.Version = "20130627091747"
+		failed.Status = models.StatusFailed
+
+		repo := newTestRepo()
+		repo.builds = append(repo.builds, &succeeded, &failed)
+		buildRepo = repo
+
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost"+tc.reqPath, nil)
+		getBuildsHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+			continue
+		}
+		if tc.expectedStatus != http.StatusOK {
+			continue
+		}
+
+		builds := make([]*models.Build, 0)
+		json.NewDecoder(recorder.Body).Decode(&builds)
+		if len(builds) != tc.expectedCount {
+			t.Errorf("Expected %v builds, Got %v (%d)", tc.expectedCount, len(builds), i)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultCertCheckInterval = 30 * time.Second
+
+// tlsCipherSuites are the TLS 1.2 cipher suites offered, all with forward
+// secrecy and authenticated encryption. TLS 1.3 suites aren't configurable.
+var tlsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+}
+
+// certReloader serves a certificate loaded from PEM files, reloading it when
+// either file changes on disk
+type certReloader struct {
+	certFile      string
+	keyFile       string
+	checkInterval time.Duration // How often to check the files for changes
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time // The latest modification time of the files when loaded
+	lastCheck time.Time
+}
+
+// newCertReloader loads the certificate and key, failing if they can't be
+// read
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		checkInterval: defaultCertCheckInterval,
+	}
+
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := cr.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{cr.certFile, cr.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// load reads the certificate. The caller must hold the lock, or be the only
+// user of the reloader.
+func (cr *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error loading TLS certificate: %v", err)
+	}
+
+	cr.cert = &cert
+	cr.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the current certificate, first reloading it if the
+// files have changed. A certificate which fails to load is logged, and the
+// previous one kept.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(cr.lastCheck) < cr.checkInterval {
+		return cr.cert, nil
+	}
+	cr.lastCheck = now
+
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		log.Printf("Error checking TLS certificate: %v", err)
+		return cr.cert, nil
+	}
+	if modTime.Equal(cr.modTime) {
+		return cr.cert, nil
+	}
+
+	if err := cr.load(modTime); err != nil {
+		log.Println(err)
+	} else {
+		log.Println("Reloaded TLS certificate", cr.certFile)
+	}
+	return cr.cert, nil
+}
+
+// tlsConfig returns the configuration of the TLS listener, requiring TLS 1.2
+// or later. If clientCAs is set, clients may present a certificate signed by
+// one of them, and are refused if the certificate can't be verified.
+func tlsConfig(cr *certReloader, clientCAs *x509.CertPool) *tls.Config {
+	config := &tls.Config{
+		NextProtos:       []string{"http/1.1"},
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     tlsCipherSuites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		GetCertificate:   cr.GetCertificate,
+	}
+
+	if clientCAs != nil {
+		config.ClientCAs = clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config
+}
+
+// serveTLS serves HTTPS on the address of the server
+func serveTLS(s *http.Server, config *tls.Config) error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(tls.NewListener(ln, config))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self signed certificate and key for the common name
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func certCommonName(t *testing.T, cert *tls.Certificate) string {
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cr.checkInterval = 0
+
+	cert, _ := cr.GetCertificate(nil)
+	if name := certCommonName(t, cert); name != "first" {
+		t.Errorf("Expected %v, Got %v", "first", name)
+	}
+
+	// Replace the certificate, making sure the modification time changes
+	writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	cert, _ = cr.GetCertificate(nil)
+	if name := certCommonName(t, cert); name != "second" {
+		t.Errorf("Expected %v, Got %v", "second", name)
+	}
+
+	// A broken certificate is ignored
+	ioutil.WriteFile(keyFile, []byte("nonsense"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(keyFile, later, later)
+
+	cert, _ = cr.GetCertificate(nil)
+	if name := certCommonName(t, cert); name != "second" {
+		t.Errorf("Expected %v, Got %v", "second", name)
+	}
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Error("Expected an error loading a broken key")
+	}
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("Expected an error loading a missing certificate")
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	config := tlsConfig(new(certReloader), nil)
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2 or later, Got %x", config.MinVersion)
+	}
+	for _, id := range config.CipherSuites {
+		for _, insecure := range tls.InsecureCipherSuites() {
+			if id == insecure.ID {
+				t.Errorf("Expected no insecure cipher suites, Got %v", insecure.Name)
+			}
+		}
+	}
+}
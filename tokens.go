@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+	"github.com/HailoOSS/build-service/validate"
+)
+
+const (
+	tokenIDBytes     = 8
+	tokenSecretBytes = 32
+)
+
+// errInvalidToken is returned for a bearer token which doesn't exist or has
+// been revoked
+var errInvalidToken = errors.New("Invalid token")
+
+// newToken generates a token, returning its ID, the token itself and its hash
+func newToken() (string, string, string, error) {
+	id := make([]byte, tokenIDBytes)
+	secret := make([]byte, tokenSecretBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	token := hex.EncodeToString(id) + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return hex.EncodeToString(id), token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of a token. Tokens are long and random, so
+// a fast unsalted hash is enough to make a leaked table useless.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken returns the token from an Authorization: Bearer header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// tokenPermissions looks up the permissions of a bearer token
+func tokenPermissions(token string) (string, *Permissions, error) {
+	t, err := buildRepo.GetToken(hashToken(token))
+	if err != nil {
+		return "", nil, err
+	}
+	if t == nil || t.RevokedAt != 0 {
+		return "", nil, errInvalidToken
+	}
+	return "token " + t.ID, &Permissions{Actions: t.Scopes, Prefixes: t.Prefixes}, nil
+}
+
+// createToken generates a token with the ID, token and hash filled in, and
+// saves it
+func createToken(t *models.APIToken, createdBy string) error {
+	var err error
+	if t.ID, t.Token, t.Hash, err = newToken(); err != nil {
+		return err
+	}
+	t.CreatedAt = time.Now().Unix()
+	t.CreatedBy = createdBy
+	t.RevokedAt = 0
+
+	return buildRepo.CreateToken(t)
+}
+
+// authorizeTokens checks that the client may manage tokens, writing an error
+// if not. Without authorization anyone could create a token which would
+// become valid once it's enabled, so tokens can then only be created with
+// create-token.
+func authorizeTokens(rw http.ResponseWriter, r *http.Request) bool {
+	if !authEnabled() {
+		logHTTPError(rw, "Tokens can only be managed with authorization enabled", http.StatusForbidden)
+		return false
+	}
+	return authorize(rw, r, actionAdmin, "")
+}
+
+func createTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("POST TOKEN", r.URL)
+
+	if !authorizeTokens(rw, r) {
+		return
+	}
+
+	if r.Body == nil {
+		logHTTPError(rw, "No POST body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	t := new(models.APIToken)
+	if p := decodeJSON(r.Body, t); p != nil {
+		logProblem(rw, p)
+		return
+	}
+
+	if errors := validate.Validate(t); len(errors) > 0 {
+		logProblem(rw, &problem{
+			Title:  "Invalid token",
+			Status: http.StatusUnprocessableEntity,
+			Errors: errors,
+		})
+		return
+	}
+
+	client, _, _ := requestPermissions(r)
+	if err := createToken(t, client); err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error creating token: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	json.NewEncoder(rw).Encode(t)
+}
+
+func getTokensHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET TOKENS", r.URL)
+
+	if !authorizeTokens(rw, r) {
+		return
+	}
+
+	tokens, err := buildRepo.GetTokens()
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting tokens: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(tokens)
+}
+
+func revokeTokenHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("DELETE TOKEN", r.URL)
+
+	if !authorizeTokens(rw, r) {
+		return
+	}
+
+	id := r.URL.Query().Get(":id")
+	if id == "" {
+		logHTTPError(rw, "Missing token ID", http.StatusBadRequest)
+		return
+	}
+
+	found, err := buildRepo.RevokeToken(id, time.Now().Unix())
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error revoking token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		logHTTPError(rw, "Token not found", http.StatusNotFound)
+		return
+	}
+}
+
+// createTokenMain creates a token from the command line, so that the first
+// admin token can be made
+func createTokenMain(args []string) {
+	fs := flag.NewFlagSet("create-token", flag.ExitOnError)
+	name := fs.String("name", "", "Who or what the token is for")
+	scopes := fs.String("scopes", actionRead, "A comma separated list of scopes: read, builds:write, builds:delete or admin")
+	prefixes := fs.String("prefixes", "", "A comma separated list of the service name prefixes which may be written or deleted")
+	fs.Parse(args)
+
+	t := &models.APIToken{
+		Name:   *name,
+		Scopes: splitList(*scopes),
+	}
+	t.Prefixes = splitList(*prefixes)
+
+	if errors := validate.Validate(t); len(errors) > 0 {
+		for _, e := range errors {
+			fmt.Fprintln(os.Stderr, e.Message)
+		}
+		os.Exit(2)
+	}
+
+	if err := createToken(t, "command line"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println(t.Token)
+}
+
+// splitList splits a comma separated list, dropping blank items
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+// addTestToken creates a token directly in the repo, returning the token
+func addTestToken(t *testing.T, repo *memoryRepo, scopes, prefixes []string) string {
+	id, token, hash, err := newToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.tokens = append(repo.tokens, &models.APIToken{ID: id, Name: "test", Scopes: scopes, Prefixes: prefixes, Hash: hash})
+	return token
+}
+
+func TestTokenAuthorization(t *testing.T) {
+	defer func() { requireTokens = false }()
+	requireTokens = true
+
+	repo := newTestRepo()
+	buildRepo = repo
+
+	admin := addTestToken(t, repo, []string{actionAdmin}, nil)
+	reader := addTestToken(t, repo, []string{actionRead}, nil)
+	kernel := addTestToken(t, repo, []string{actionRead, actionWrite}, []string{"com.HailoOSS.kernel."})
+	writer := addTestToken(t, repo, []string{actionRead, actionWrite, actionDelete}, nil)
+
+	build, _ := json.Marshal(validBuild())
+
+	testCases := []struct {
+		method         string
+		path           string
+		body           []byte
+		token          string
+		expectedStatus int
+	}{
+		{"GET", "/builds", nil, "", http.StatusUnauthorized},
+		{"GET", "/builds", nil, "nonsense", http.StatusUnauthorized},
+		{"GET", "/builds", nil, reader, http.StatusOK},
+		{"POST", "/builds", build, reader, http.StatusForbidden},
+		{"POST", "/builds", build, kernel, http.StatusOK},
+		{"DELETE", "/builds/com.HailoOSS.kernel.build-service/20130627091746", nil, kernel, http.StatusForbidden},
+		{"GET", "/tokens", nil, kernel, http.StatusForbidden},
+		{"GET", "/tokens", nil, admin, http.StatusOK},
+		{"POST", "/tokens", []byte(`{"Name":"ci","Scopes":["builds:write"]}`), admin, http.StatusCreated},
+		{"POST", "/tokens", []byte(`{"Name":"ci","Scopes":["everything"]}`), admin, http.StatusUnprocessableEntity},
+		{"POST", "/tokensx", []byte(`{"Name":"ci","Scopes":["admin"]}`), writer, http.StatusForbidden},
+		{"GET", "/tokensx", nil, reader, http.StatusForbidden},
+		{"GET", "/tokens/", nil, reader, http.StatusForbidden},
+		{"DELETE", "/tokens/abc/x", nil, writer, http.StatusForbidden},
+		{"GET", "/tokensx", nil, admin, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, bytes.NewReader(tc.body))
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+}
+
+func TestTokensWithoutAuthorization(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+	admin := addTestToken(t, repo, []string{actionAdmin}, nil)
+
+	testCases := []struct {
+		method string
+		path   string
+		body   string
+		token  string
+	}{
+		{"POST", "/tokens", `{"Name":"backdoor","Scopes":["admin"]}`, ""},
+		{"POST", "/tokens", `{"Name":"backdoor","Scopes":["admin"]}`, admin},
+		{"GET", "/tokens", "", ""},
+		{"DELETE", "/tokens/" + repo.tokens[0].ID, "", ""},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("Expected %v, Got %v: %s (%d)", http.StatusForbidden, recorder.Code, recorder.Body, i)
+		}
+	}
+
+	if len(repo.tokens) != 1 || repo.tokens[0].RevokedAt != 0 {
+		t.Errorf("Expected the tokens to be unchanged, Got %+v", repo.tokens)
+	}
+}
+
+func TestCreateAndRevokeToken(t *testing.T) {
+	defer func() { requireTokens = false }()
+	requireTokens = true
+
+	repo := newTestRepo()
+	buildRepo = repo
+	admin := addTestToken(t, repo, []string{actionAdmin}, nil)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/tokens", strings.NewReader(`{"Name":"ci","Scopes":["read"],"Prefixes":["com.HailoOSS."]}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	createTokenHandler(recorder, req)
+
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected %v, Got %v: %s", http.StatusCreated, recorder.Code, recorder.Body)
+	}
+
+	created := new(models.APIToken)
+	json.NewDecoder(recorder.Body).Decode(created)
+	if created.Token == "" || created.ID == "" || strings.Contains(recorder.Body.String(), repo.tokens[1].Hash) {
+		t.Fatalf("Expected the token but not its hash, Got %+v", created)
+	}
+	if repo.tokens[1].Hash != hashToken(created.Token) {
+		t.Errorf("Expected the hash of the token to be stored")
+	}
+
+	if _, perms, err := tokenPermissions(created.Token); err != nil || !perms.Allows(actionRead, "") {
+		t.Errorf("Expected the token to allow reading, Got %v %v", perms, err)
+	}
+
+	testCases := []struct {
+		id             string
+		expectedStatus int
+	}{
+		{created.ID, http.StatusOK},
+		{created.ID, http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/tokens?:id="+tc.id, nil)
+		req.Header.Set("Authorization", "Bearer "+admin)
+		revokeTokenHandler(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expectedStatus, recorder.Code, i)
+		}
+	}
+
+	if _, _, err := tokenPermissions(created.Token); err != errInvalidToken {
+		t.Errorf("Expected a revoked token to be invalid, Got %v", err)
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	defer func(origins string) { corsOrigins = origins }(corsOrigins)
+
+	testCases := []struct {
+		origins  string
+		origin   string
+		expected string
+	}{
+		{"*", "", "*"},
+		{"*", "https://example.com", "*"},
+		{"https://dashboard.hailo.com", "https://dashboard.hailo.com", "https://dashboard.hailo.com"},
+		{"https://dashboard.hailo.com", "https://example.com", ""},
+		{"", "https://example.com", ""},
+	}
+
+	for i, tc := range testCases {
+		corsOrigins = tc.origins
+		buildRepo = newTestRepo()
+
+		req, _ := http.NewRequest("GET", "/builds", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != tc.expected {
+			t.Errorf("Expected %q, Got %q (%d)", tc.expected, allowed, i)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	defer func(origins string) { corsOrigins = origins }(corsOrigins)
+	defer func() { requireTokens = false }()
+	requireTokens = true
+
+	testCases := []struct {
+		origins        string
+		origin         string
+		expectedOrigin string
+		expectHeaders  bool
+	}{
+		{"*", "https://example.com", "*", true},
+		{"https://dashboard.hailo.com", "https://dashboard.hailo.com", "https://dashboard.hailo.com", true},
+		{"https://dashboard.hailo.com", "https://example.com", "", false},
+	}
+
+	for i, tc := range testCases {
+		corsOrigins = tc.origins
+		buildRepo = newTestRepo()
+
+		req, _ := http.NewRequest("OPTIONS", "/builds", nil)
+		req.Header.Set("Origin", tc.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "authorization")
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusNoContent {
+			t.Errorf("Expected %v, Got %v (%d)", http.StatusNoContent, recorder.Code, i)
+		}
+		if allowed := recorder.Header().Get("Access-Control-Allow-Origin"); allowed != tc.expectedOrigin {
+			t.Errorf("Expected %q, Got %q (%d)", tc.expectedOrigin, allowed, i)
+		}
+		if headers := recorder.Header().Get("Access-Control-Allow-Headers"); strings.Contains(headers, "Authorization") != tc.expectHeaders {
+			t.Errorf("Expected Authorization to be allowed: %v, Got %q (%d)", tc.expectHeaders, headers, i)
+		}
+	}
+}
+
+func TestPublicBadges(t *testing.T) {
+	defer func() { requireTokens, publicBadges = false, false }()
+	requireTokens = true
+
+	repo := newTestRepo()
+	repo.builds = append(repo.builds, &models.Build{Name: "com.HailoOSS.kernel.build-service", Version: "20130627091746", Branch: "master", Status: models.StatusSucceeded, TimeStamp: 1372346773})
+	buildRepo = repo
+
+	testCases := []struct {
+		public         bool
+		path           string
+		expectedStatus int
+	}{
+		{true, "/badges/com.HailoOSS.kernel.build-service/version.svg", http.StatusOK},
+		{true, "/builds/com.HailoOSS.kernel.build-service", http.StatusUnauthorized},
+		{false, "/badges/com.HailoOSS.kernel.build-service/version.svg", http.StatusUnauthorized},
+	}
+
+	for i, tc := range testCases {
+		publicBadges = tc.public
+
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+		}
+	}
+}
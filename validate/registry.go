@@ -0,0 +1,124 @@
+package validate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// builtinRules are the rules understood by checkRule, and the markers used
+// to validate the elements of a slice or map
+var builtinRules = map[string]bool{
+	"nonblank":   true,
+	"url":        true,
+	"oneof":      true,
+	"maxlen":     true,
+	"min":        true,
+	"max":        true,
+	"numeric":    true,
+	"timestamp":  true,
+	"sha1":       true,
+	"sha256":     true,
+	"importpath": true,
+	"regex":      true,
+	"dive":       true,
+	"keys":       true,
+	"endkeys":    true,
+}
+
+var (
+	customRules = make(map[string]RuleFunc)
+	checks      = make(map[string]CheckFunc)
+	registryMu  sync.RWMutex
+)
+
+// RuleFunc checks the value of a field against a custom rule. arg is the text
+// after the equals sign in the tag, if any. The error describes the failure
+// without the field name, eg "must start with com.HailoOSS.". Like the built
+// in rules, custom rules aren't checked against empty values.
+type RuleFunc func(value, arg string) error
+
+// CheckFunc checks a whole struct, for rules which span several fields. s is
+// the struct passed to ValidateWith.
+type CheckFunc func(s interface{}) []*FieldError
+
+// Policy is a set of rules applied to a struct on top of its tags
+type Policy struct {
+	Fields map[string]string `json:",omitempty"` // Extra rules by field name, as they appear in FieldError.Field. They can't dive.
+	Checks []string          `json:",omitempty"` // The names of registered checks to run
+}
+
+// RegisterRule makes a custom rule available to struct tags and policies. It
+// panics if the name is already taken.
+func RegisterRule(name string, fn RuleFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if name == "" || strings.ContainsAny(name, ",=") {
+		panic(fmt.Sprintf("validate: invalid rule name %q", name))
+	}
+	if _, ok := customRules[name]; ok || builtinRules[name] {
+		panic(fmt.Sprintf("validate: rule %q registered twice", name))
+	}
+	customRules[name] = fn
+}
+
+// RegisterCheck makes a cross-field check available to policies. It panics if
+// the name is already taken.
+func RegisterCheck(name string, fn CheckFunc) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := checks[name]; ok || name == "" {
+		panic(fmt.Sprintf("validate: check %q registered twice", name))
+	}
+	checks[name] = fn
+}
+
+func lookupRule(name string) (RuleFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	fn, ok := customRules[name]
+	return fn, ok
+}
+
+func lookupCheck(name string) (CheckFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	fn, ok := checks[name]
+	return fn, ok
+}
+
+// CheckTag returns an error if the tag uses a rule which isn't built in or
+// registered
+func CheckTag(tag string) error {
+	for _, rule := range splitRules(tag) {
+		if i := strings.Index(rule, "="); i != -1 {
+			rule = rule[:i]
+		}
+		if _, ok := lookupRule(rule); !ok && !builtinRules[rule] {
+			return fmt.Errorf("Unknown validation rule %q", rule)
+		}
+	}
+	return nil
+}
+
+// Check returns an error if the policy uses an unknown rule or check
+func (p *Policy) Check() error {
+	for field, tag := range p.Fields {
+		if err := CheckTag(tag); err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+		if _, _, diving := splitDive(tag); diving {
+			return fmt.Errorf("%s: policy rules can't dive", field)
+		}
+	}
+	for _, name := range p.Checks {
+		if _, ok := lookupCheck(name); !ok {
+			return fmt.Errorf("Unknown validation check %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func init() {
+	RegisterRule("testprefix", func(value, arg string) error {
+		if !strings.HasPrefix(value, arg) {
+			return fmt.Errorf("must start with %s", arg)
+		}
+		return nil
+	})
+
+	RegisterCheck("testordered", func(s interface{}) []*FieldError {
+		r := s.(*testRange)
+		if r.Min > r.Max {
+			return []*FieldError{{Field: "Min", Path: "min", Message: "Min must not be greater than Max"}}
+		}
+		return nil
+	})
+}
+
+type testRange struct {
+	Name string `validate:"testprefix=com."`
+	Min  int    `json:"min"`
+	Max  int    `json:"max"`
+}
+
+func TestCustomRule(t *testing.T) {
+	testCases := []struct {
+		s        testRange
+		expected []string
+	}{
+		{testRange{Name: "com.HailoOSS"}, nil},
+		{testRange{}, nil},
+		{testRange{Name: "org.HailoOSS"}, []string{"Name must start with com."}},
+	}
+
+	for i, tc := range testCases {
+		errors := Validate(&tc.s)
+		if len(errors) != len(tc.expected) {
+			t.Errorf("Expected %v errors, got %v (%d)", tc.expected, errors, i)
+			continue
+		}
+		for j, err := range errors {
+			if err.Error() != tc.expected[j] {
+				t.Errorf("Expected %q, got %q (%d)", tc.expected[j], err, i)
+			}
+		}
+	}
+}
+
+func TestValidateWith(t *testing.T) {
+	p := &Policy{
+		Fields: map[string]string{"Name": "testprefix=com.HailoOSS.,maxlen=20"},
+		Checks: []string{"testordered"},
+	}
+
+	testCases := []struct {
+		s        testRange
+		p        *Policy
+		expected []*FieldError
+	}{
+		{testRange{Name: "com.HailoOSS.kernel", Min: 1, Max: 2}, p, nil},
+		{testRange{Name: "com.HailoOSS.kernel", Min: 3, Max: 2}, nil, nil},
+		{testRange{Name: "com.example", Min: 3, Max: 2}, p, []*FieldError{
+			{Field: "Name", Path: "Name", Rule: "testprefix", Message: "Name must start with com.HailoOSS."},
+			{Field: "Min", Path: "min", Rule: "testordered", Message: "Min must not be greater than Max"},
+		}},
+		{testRange{Name: "com.HailoOSS.kernel"}, &Policy{Checks: []string{"missing"}}, []*FieldError{
+			{Rule: "missing", Message: `Unknown validation check "missing"`},
+		}},
+	}
+
+	for i, tc := range testCases {
+		errors := ValidateWith(&tc.s, tc.p)
+		if len(errors) != len(tc.expected) {
+			t.Errorf("Expected %v errors, got %v (%d)", len(tc.expected), errors, i)
+			continue
+		}
+		for j, err := range errors {
+			if !reflect.DeepEqual(err, tc.expected[j]) {
+				t.Errorf("Expected %+v, got %+v (%d)", tc.expected[j], err, i)
+			}
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	testCases := []struct {
+		p           Policy
+		expectError bool
+	}{
+		{Policy{Fields: map[string]string{"Name": "nonblank,testprefix=com."}, Checks: []string{"testordered"}}, false},
+		{Policy{Fields: map[string]string{"Name": "shiny"}}, true},
+		{Policy{Fields: map[string]string{"Coverage": "dive,max=100"}}, true},
+		{Policy{Checks: []string{"missing"}}, true},
+	}
+
+	for i, tc := range testCases {
+		if err := tc.p.Check(); (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic registering a built in rule")
+		}
+	}()
+	RegisterRule("url", func(value, arg string) error { return nil })
+}
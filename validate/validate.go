@@ -2,51 +2,469 @@ package validate
 
 import (
 	"fmt"
+	"net/url"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+const timestampLayout = "20060102150405"
+
+var (
+	defaultURLSchemes = []string{"http", "https"}
+	regNumeric        = regexp.MustCompile(`^[0-9]+$`)
+	regSHA1           = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	regSHA256         = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	regImportPath     = regexp.MustCompile(`^[a-zA-Z0-9_~+-]+(\.[a-zA-Z0-9_~+-]+)*(/[a-zA-Z0-9_~+-]+(\.[a-zA-Z0-9_~+-]+)*)*$`)
+
+	regexCache   = make(map[string]*regexp.Regexp)
+	regexCacheMu sync.Mutex
+)
+
+// FieldError is a failure of a field to meet a validation rule
+type FieldError struct {
+	Field   string `json:"field"`   // The name of the struct field
+	Path    string `json:"path"`    // The path to the field in the JSON encoding of the struct
+	Rule    string `json:"rule"`    // The rule that failed, without its argument
+	Message string `json:"message"` // A human readable description of the failure
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
+
 // Validate will inspect the struct, s using reflection
 // and check that it meets the specified validation rules
 // defined as struct tags.
-func Validate(s interface{}) []error {
-	errors := make([]error, 0)
+//
+// Rules are separated by commas, and some take an argument after an equals
+// sign:
+//
+//	nonblank          - must not be empty, or for a slice or map have no elements
+//	url               - must be an absolute http or https URL
+//	url=http|s3       - must be an absolute URL with one of the schemes
+//	oneof=Go|Java     - must be one of the values
+//	maxlen=N          - must be at most N characters
+//	min=N             - must be a number no less than N
+//	max=N             - must be a number no greater than N
+//	numeric           - must only contain digits
+//	timestamp         - must be a date formatted as 20060102150405
+//	sha1              - must be a 40 character lower case hex SHA
+//	sha256            - must be a 64 character lower case hex SHA-256
+//	importpath        - must look like a Go import path
+//	regex=^[a-z]+$    - must match the regular expression, which takes the
+//	                    rest of the tag so it may contain commas
+//
+// All rules but nonblank accept an empty value.
+//
+// Nested structs are always validated. The rules after dive apply to each
+// element of a slice or map rather than to the field itself, and for a map
+// the rules between keys and endkeys apply to each key:
+//
+//	validate:"dive,min=0,max=100"
+//	validate:"nonblank,dive,keys,importpath,endkeys,sha1"
+//
+// Custom rules added with RegisterRule can be used in the same way.
+func Validate(s interface{}) []*FieldError {
+	return ValidateWith(s, nil)
+}
 
-	v := reflect.ValueOf(s)
-	t := v.Type()
+// ValidateWith validates the struct, s against its tags and then the extra
+// field rules and cross-field checks of the policy, which may be nil
+func ValidateWith(s interface{}, p *Policy) []*FieldError {
+	return ValidateWithPolicies(s, p)
+}
+
+// ValidateWithPolicies validates the struct, s against its tags once, and
+// then the extra field rules of each policy and each of their checks once
+func ValidateWithPolicies(s interface{}, policies ...*Policy) []*FieldError {
+	errors := make([]*FieldError, 0)
 
-	if t.Kind() == reflect.Ptr {
+	extra := make(map[string][]string)
+	checks := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, p := range policies {
+		if p == nil {
+			continue
+		}
+		for field, tag := range p.Fields {
+			extra[field] = append(extra[field], tag)
+		}
+		for _, name := range p.Checks {
+			if !seen[name] {
+				seen[name] = true
+				checks = append(checks, name)
+			}
+		}
+	}
+
+	v := reflect.ValueOf(s)
+	for v.Kind() == reflect.Ptr {
 		v = v.Elem()
-		t = v.Type()
 	}
+	if v.Kind() != reflect.Struct {
+		return errors
+	}
+
+	errors = validateStruct(v, "", "", extra, errors)
+
+	for _, name := range checks {
+		check, ok := lookupCheck(name)
+		if !ok {
+			errors = append(errors, &FieldError{Rule: name, Message: fmt.Sprintf("Unknown validation check %q", name)})
+			continue
+		}
+		for _, err := range check(s) {
+			if err.Rule == "" {
+				err.Rule = name
+			}
+			errors = append(errors, err)
+		}
+	}
+
+	return errors
+}
 
-	nonblank := make([]string, 0)
-	urls := make([]string, 0)
+// validateStruct checks each field of a struct. Embedded structs without a
+// JSON name are validated as if their fields belonged to the parent. extra
+// holds further rules for fields by name.
+func validateStruct(v reflect.Value, name, path string, extra map[string][]string, errors []*FieldError) []*FieldError {
+	t := v.Type()
 
 	for i := 0; i < t.NumField(); i++ {
 		f := t.Field(i)
-		validate := f.Tag.Get("validate")
-		if validate != "" {
-			parts := strings.Split(validate, ",")
-			for _, p := range parts {
-				if p == "nonblank" {
-					nonblank = append(nonblank, f.Name)
-				}
-				if p == "url" {
-					urls = append(urls, f.Name)
-				}
+
+		fieldName, fieldPath := joinPath(name, f.Name), joinPath(path, jsonName(f))
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			fieldName, fieldPath = name, path
+		}
+
+		errors = validateValue(v.Field(i), f.Tag.Get("validate"), fieldName, fieldPath, extra, errors)
+		if fieldName == name {
+			continue
+		}
+		for _, tag := range extra[fieldName] {
+			errors = checkRules(indirect(v.Field(i)), splitRules(tag), fieldName, fieldPath, errors)
+		}
+	}
+
+	return errors
+}
+
+// validateValue checks a value against the rules in tag, then validates the
+// fields of a struct or, after a dive, the elements of a slice or map
+func validateValue(v reflect.Value, tag, name, path string, extra map[string][]string, errors []*FieldError) []*FieldError {
+	rules, dive, diving := splitDive(tag)
+	v = indirect(v)
+
+	errors = checkRules(v, rules, name, path, errors)
+
+	switch v.Kind() {
+	case reflect.Struct:
+		errors = validateStruct(v, name, path, extra, errors)
+	case reflect.Slice, reflect.Array:
+		if !diving {
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			index := fmt.Sprintf("[%d]", i)
+			errors = validateValue(v.Index(i), dive, name+index, path+index, extra, errors)
+		}
+	case reflect.Map:
+		if !diving {
+			break
+		}
+		keyTag, elemTag := splitKeyRules(dive)
+
+		keys := v.MapKeys()
+		sort.Sort(byKey(keys))
+		for _, k := range keys {
+			key := keyString(k)
+			index := fmt.Sprintf("[%q]", key)
+			if keyTag != "" {
+				errors = validateValue(k, keyTag, fmt.Sprintf("%s key %q", name, key), path+index, extra, errors)
 			}
+			errors = validateValue(v.MapIndex(k), elemTag, name+index, path+index, extra, errors)
 		}
 	}
 
-	for _, nb := range nonblank {
-		f := v.FieldByName(nb)
-		s := f.String()
+	return errors
+}
+
+// indirect follows pointers and interfaces, stopping at nil
+func indirect(v reflect.Value) reflect.Value {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// checkRules checks a single value against each of the rules
+func checkRules(v reflect.Value, rules []string, name, path string, errors []*FieldError) []*FieldError {
+	for _, rule := range rules {
+		ruleName, arg := rule, ""
+		if j := strings.Index(rule, "="); j != -1 {
+			ruleName, arg = rule[:j], rule[j+1:]
+		}
+
+		var err error
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Array:
+			if ruleName == "nonblank" && isEmpty(v) {
+				err = fmt.Errorf("%s cannot be blank", name)
+			}
+		default:
+			value, ok := fieldString(v)
+			if !ok {
+				continue
+			}
+			err = checkRule(name, ruleName, arg, value)
+		}
 
-		if s == "" {
-			errors = append(errors, fmt.Errorf("%s cannot be blank", nb))
+		if err != nil {
+			errors = append(errors, &FieldError{
+				Field:   name,
+				Path:    path,
+				Rule:    ruleName,
+				Message: err.Error(),
+			})
 		}
 	}
 
 	return errors
 }
+
+// splitDive separates the rules for a field from the tag to apply to its
+// elements after a dive
+func splitDive(tag string) ([]string, string, bool) {
+	rules := splitRules(tag)
+	for i, rule := range rules {
+		if rule == "dive" {
+			return rules[:i], strings.Join(rules[i+1:], ","), true
+		}
+	}
+	return rules, "", false
+}
+
+// splitKeyRules separates the tags for the keys and values of a map. The key
+// rules must come first, between keys and endkeys.
+func splitKeyRules(tag string) (string, string) {
+	rules := splitRules(tag)
+	if len(rules) == 0 || rules[0] != "keys" {
+		return "", tag
+	}
+	for i, rule := range rules {
+		if rule == "endkeys" {
+			return strings.Join(rules[1:i], ","), strings.Join(rules[i+1:], ",")
+		}
+	}
+	return strings.Join(rules[1:], ","), ""
+}
+
+// joinPath appends a field name to the path of its parent
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// fieldString returns the value of a string or numeric field as a string
+func fieldString(f reflect.Value) (string, bool) {
+	switch f.Kind() {
+	case reflect.String:
+		return f.String(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(f.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(f.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// keyString returns a map key as a string for sorting and error messages
+func keyString(k reflect.Value) string {
+	if s, ok := fieldString(k); ok {
+		return s
+	}
+	if k.CanInterface() {
+		return fmt.Sprint(k.Interface())
+	}
+	return k.String()
+}
+
+// isEmpty returns whether a nil pointer, or a slice or map has no elements
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	}
+	return false
+}
+
+// byKey sorts map keys so that errors are reported in a stable order
+type byKey []reflect.Value
+
+func (k byKey) Len() int           { return len(k) }
+func (k byKey) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k byKey) Less(i, j int) bool { return keyString(k[i]) < keyString(k[j]) }
+
+// jsonName returns the name of a field when encoded as JSON
+func jsonName(f reflect.StructField) string {
+	tag := f.Tag.Get("json")
+	if i := strings.Index(tag, ","); i != -1 {
+		tag = tag[:i]
+	}
+	if tag == "" || tag == "-" {
+		return f.Name
+	}
+	return tag
+}
+
+// splitRules splits a tag into its rules. A regex rule takes the rest of the
+// tag, so that the expression can contain commas.
+func splitRules(tag string) []string {
+	rules := make([]string, 0)
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			return append(rules, tag)
+		}
+		i := strings.Index(tag, ",")
+		if i == -1 {
+			return append(rules, tag)
+		}
+		if tag[:i] != "" {
+			rules = append(rules, tag[:i])
+		}
+		tag = tag[i+1:]
+	}
+	return rules
+}
+
+func checkRule(field, rule, arg, value string) error {
+	if rule == "nonblank" {
+		if value == "" {
+			return fmt.Errorf("%s cannot be blank", field)
+		}
+		return nil
+	}
+
+	if value == "" {
+		return nil
+	}
+
+	switch rule {
+	case "url":
+		schemes := defaultURLSchemes
+		if arg != "" {
+			schemes = strings.Split(arg, "|")
+		}
+		u, err := url.Parse(value)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return fmt.Errorf("%s must be a URL", field)
+		}
+		if !contains(schemes, strings.ToLower(u.Scheme)) {
+			return fmt.Errorf("%s must be a %s URL", field, strings.Join(schemes, " or "))
+		}
+	case "oneof":
+		values := strings.Split(arg, "|")
+		if !contains(values, value) {
+			return fmt.Errorf("%s must be one of %s", field, strings.Join(values, ", "))
+		}
+	case "maxlen":
+		max, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("%s has an invalid maxlen %q", field, arg)
+		}
+		if len(value) > max {
+			return fmt.Errorf("%s must be at most %d characters", field, max)
+		}
+	case "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return fmt.Errorf("%s has an invalid %s %q", field, rule, arg)
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number", field)
+		}
+		if rule == "min" && n < limit {
+			return fmt.Errorf("%s must be at least %s", field, arg)
+		}
+		if rule == "max" && n > limit {
+			return fmt.Errorf("%s must be at most %s", field, arg)
+		}
+	case "sha1":
+		if !regSHA1.MatchString(value) {
+			return fmt.Errorf("%s must be a 40 character hex SHA", field)
+		}
+	case "sha256":
+		if !regSHA256.MatchString(value) {
+			return fmt.Errorf("%s must be a 64 character hex SHA-256", field)
+		}
+	case "importpath":
+		if !regImportPath.MatchString(value) {
+			return fmt.Errorf("%s must be an import path", field)
+		}
+	case "numeric":
+		if !regNumeric.MatchString(value) {
+			return fmt.Errorf("%s must be numeric", field)
+		}
+	case "timestamp":
+		_, err := time.Parse(timestampLayout, value)
+		if err != nil || len(value) != len(timestampLayout) || !regNumeric.MatchString(value) {
+			return fmt.Errorf("%s must be a timestamp formatted as YYYYMMDDhhmmss", field)
+		}
+	case "regex":
+		re, err := compileRegex(arg)
+		if err != nil {
+			return fmt.Errorf("%s has an invalid regex %q: %v", field, arg, err)
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s must match %s", field, arg)
+		}
+	default:
+		fn, ok := lookupRule(rule)
+		if !ok {
+			return fmt.Errorf("%s has an unknown validation rule %q", field, rule)
+		}
+		if err := fn(value, arg); err != nil {
+			return fmt.Errorf("%s %v", field, err)
+		}
+	}
+
+	return nil
+}
+
+// compileRegex compiles a regular expression, caching the result
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	regexCacheMu.Lock()
+	defer regexCacheMu.Unlock()
+
+	if re, ok := regexCache[expr]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCache[expr] = re
+	return re, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package validate
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -28,3 +29,175 @@ func TestBlank(t *testing.T) {
 	}
 
 }
+
+func TestRules(t *testing.T) {
+	type testStruct struct {
+		URL       string `validate:"url"`
+		S3URL     string `validate:"url=s3|https"`
+		Language  string `validate:"oneof=Go|Java"`
+		Short     string `validate:"maxlen=3"`
+		Number    string `validate:"numeric"`
+		Count     int    `validate:"maxlen=2"`
+		Timestamp string `validate:"nonblank,timestamp"`
+		Regex     string `validate:"regex=^[a-z]{1,3}$"`
+		Checksum  string `validate:"sha256"`
+	}
+
+	valid := testStruct{
+		URL:       "https://github.com/HailoOSS/build-service",
+		S3URL:     "s3://bucket/key",
+		Language:  "Go",
+		Short:     "abc",
+		Number:    "0123",
+		Count:     10,
+		Timestamp: "20130627091746",
+		Regex:     "abc",
+		Checksum:  "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+
+	testCases := []struct {
+		modify   func(*testStruct)
+		expected []string
+	}{
+		{func(s *testStruct) {}, nil},
+		{func(s *testStruct) { *s = testStruct{Timestamp: "20130627091746"} }, nil},
+		{func(s *testStruct) { s.URL = "github.com/HailoOSS" }, []string{"URL must be a URL"}},
+		{func(s *testStruct) { s.URL = "ftp://github.com/HailoOSS" }, []string{"URL must be a http or https URL"}},
+		{func(s *testStruct) { s.S3URL = "http://s3.amazon.com/abc" }, []string{"S3URL must be a s3 or https URL"}},
+		{func(s *testStruct) { s.Language = "Ruby" }, []string{"Language must be one of Go, Java"}},
+		{func(s *testStruct) { s.Short = "abcd" }, []string{"Short must be at most 3 characters"}},
+		{func(s *testStruct) { s.Number = "12a" }, []string{"Number must be numeric"}},
+		{func(s *testStruct) { s.Count = 100 }, []string{"Count must be at most 2 characters"}},
+		{func(s *testStruct) { s.Timestamp = "" }, []string{"Timestamp cannot be blank"}},
+		{func(s *testStruct) { s.Timestamp = "2013062709174" }, []string{"Timestamp must be a timestamp formatted as YYYYMMDDhhmmss"}},
+		{func(s *testStruct) { s.Timestamp = "20131327091746" }, []string{"Timestamp must be a timestamp formatted as YYYYMMDDhhmmss"}},
+		{func(s *testStruct) { s.Regex = "abcd" }, []string{"Regex must match ^[a-z]{1,3}$"}},
+		{func(s *testStruct) { s.Checksum = "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08" }, []string{"Checksum must be a 64 character hex SHA-256"}},
+	}
+
+	for i, tc := range testCases {
+		s := valid
+		tc.modify(&s)
+
+		errors := Validate(s)
+		if len(errors) != len(tc.expected) {
+			t.Errorf("Expected %v errors, got %v (%d)", tc.expected, errors, i)
+			continue
+		}
+		for j, err := range errors {
+			if err.Error() != tc.expected[j] {
+				t.Errorf("Expected %q, got %q (%d)", tc.expected[j], err, i)
+			}
+		}
+	}
+}
+
+func TestUnknownRule(t *testing.T) {
+	type testStruct struct {
+		a string `validate:"nonblank,shiny"`
+	}
+
+	if errors := Validate(testStruct{"a"}); len(errors) != 1 {
+		t.Errorf("Expected an error for an unknown rule, got %v", errors)
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	type testStruct struct {
+		Name      string `validate:"nonblank"`
+		SourceURL string `json:"source_url,omitempty" validate:"url"`
+		Ignored   string `json:"-" validate:"maxlen=1"`
+	}
+
+	expected := []*FieldError{
+		{Field: "Name", Path: "Name", Rule: "nonblank", Message: "Name cannot be blank"},
+		{Field: "SourceURL", Path: "source_url", Rule: "url", Message: "SourceURL must be a URL"},
+		{Field: "Ignored", Path: "Ignored", Rule: "maxlen", Message: "Ignored must be at most 1 characters"},
+	}
+
+	errors := Validate(testStruct{SourceURL: "nonsense", Ignored: "ab"})
+	if !reflect.DeepEqual(errors, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, errors)
+	}
+}
+
+func TestDive(t *testing.T) {
+	type inner struct {
+		Percentage float64 `json:"percentage" validate:"min=0,max=100"`
+	}
+	type testStruct struct {
+		Coverage     map[string]float64 `json:"coverage" validate:"dive,min=0,max=100"`
+		Files        []inner            `json:"files" validate:"nonblank,dive"`
+		Dependencies map[string]string  `json:"dependencies" validate:"dive,keys,importpath,endkeys,sha1"`
+		Nested       *inner             `json:"nested"`
+	}
+
+	valid := testStruct{
+		Coverage:     map[string]float64{"dao": 0, "domain": 100},
+		Files:        []inner{{12.5}},
+		Dependencies: map[string]string{"github.com/HailoOSS/go-server-layer": "e6dc54ee3618c7b354dccdb6425cf4f82e07423c"},
+		Nested:       &inner{50},
+	}
+
+	testCases := []struct {
+		modify   func(*testStruct)
+		expected []*FieldError
+	}{
+		{func(s *testStruct) {}, nil},
+		{func(s *testStruct) { s.Nested = nil }, nil},
+		{func(s *testStruct) { s.Coverage = map[string]float64{"b": 101, "a": -0.5} }, []*FieldError{
+			{Field: `Coverage["a"]`, Path: `coverage["a"]`, Rule: "min", Message: `Coverage["a"] must be at least 0`},
+			{Field: `Coverage["b"]`, Path: `coverage["b"]`, Rule: "max", Message: `Coverage["b"] must be at most 100`},
+		}},
+		{func(s *testStruct) { s.Files = nil }, []*FieldError{
+			{Field: "Files", Path: "files", Rule: "nonblank", Message: "Files cannot be blank"},
+		}},
+		{func(s *testStruct) { s.Files = []inner{{1}, {200}} }, []*FieldError{
+			{Field: "Files[1].Percentage", Path: "files[1].percentage", Rule: "max", Message: "Files[1].Percentage must be at most 100"},
+		}},
+		{func(s *testStruct) {
+			s.Dependencies = map[string]string{"github.com/a b": "E6DC54EE3618C7B354DCCDB6425CF4F82E07423C"}
+		}, []*FieldError{
+			{Field: `Dependencies key "github.com/a b"`, Path: `dependencies["github.com/a b"]`, Rule: "importpath", Message: `Dependencies key "github.com/a b" must be an import path`},
+			{Field: `Dependencies["github.com/a b"]`, Path: `dependencies["github.com/a b"]`, Rule: "sha1", Message: `Dependencies["github.com/a b"] must be a 40 character hex SHA`},
+		}},
+		{func(s *testStruct) { s.Nested = &inner{-1} }, []*FieldError{
+			{Field: "Nested.Percentage", Path: "nested.percentage", Rule: "min", Message: "Nested.Percentage must be at least 0"},
+		}},
+	}
+
+	for i, tc := range testCases {
+		s := valid
+		tc.modify(&s)
+
+		errors := Validate(&s)
+		if len(errors) != len(tc.expected) {
+			t.Errorf("Expected %v errors, got %v (%d)", len(tc.expected), errors, i)
+			continue
+		}
+		for j, err := range errors {
+			if !reflect.DeepEqual(err, tc.expected[j]) {
+				t.Errorf("Expected %+v, got %+v (%d)", tc.expected[j], err, i)
+			}
+		}
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	type Report struct {
+		Total float64 `validate:"max=100"`
+	}
+	type testStruct struct {
+		Name string `validate:"nonblank"`
+		Report
+	}
+
+	expected := []*FieldError{
+		{Field: "Total", Path: "Total", Rule: "max", Message: "Total must be at most 100"},
+	}
+
+	errors := Validate(testStruct{Name: "a", Report: Report{Total: 101}})
+	if !reflect.DeepEqual(errors, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, errors)
+	}
+}
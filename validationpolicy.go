@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/HailoOSS/build-service/models"
+	"github.com/HailoOSS/build-service/validate"
+)
+
+func init() {
+	validate.RegisterRule("prefix", validatePrefix)
+	validate.RegisterRule("host", validateHost)
+	validate.RegisterCheck("goversion", checkGoVersion)
+	validate.RegisterCheck("statements", checkStatements)
+	validate.RegisterCheck("status", checkStatus)
+	validate.RegisterCheck("checksum", checkChecksum)
+	validate.RegisterCheck("artifacts", checkArtifacts)
+}
+
+var (
+	// defaultValidationPolicy is applied to every build
+	defaultValidationPolicy = validate.Policy{Checks: []string{"status", "checksum", "artifacts"}}
+
+	// uncheckedValidationPolicy replaces the default with -require-checksums=false,
+	// while clients are updated to send checksums
+	uncheckedValidationPolicy = validate.Policy{Checks: []string{"status", "artifacts"}}
+
+	requireChecksums = true
+)
+
+// ValidationPolicy adds rules to the validation of new builds of the services
+// with a name prefix
+type ValidationPolicy struct {
+	Prefix string // The service name prefix the policy applies to
+	validate.Policy
+}
+
+// ValidationPolicies is a set of policies, all of the matching ones being
+// applied to a build
+type ValidationPolicies []*ValidationPolicy
+
+// loadValidationPolicies reads a JSON list of policies from a file
+func loadValidationPolicies(path string) (ValidationPolicies, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	policies := make(ValidationPolicies, 0)
+	if err := json.NewDecoder(f).Decode(&policies); err != nil {
+		return nil, fmt.Errorf("Error decoding validation policies: %v", err)
+	}
+
+	for _, p := range policies {
+		if err := p.Check(); err != nil {
+			return nil, fmt.Errorf("Invalid validation policy for %q: %v", p.Prefix, err)
+		}
+	}
+
+	return policies, nil
+}
+
+// Validate checks a build against its struct tags, the default policy and
+// every policy with a prefix matching its name
+func (vp ValidationPolicies) Validate(b *models.Build) []*validate.FieldError {
+	policies := []*validate.Policy{&defaultValidationPolicy}
+	if !requireChecksums {
+		policies[0] = &uncheckedValidationPolicy
+	}
+	for _, p := range vp {
+		if strings.HasPrefix(b.Name, p.Prefix) {
+			policies = append(policies, &p.Policy)
+		}
+	}
+	return validate.ValidateWithPolicies(b, policies...)
+}
+
+// validatePrefix requires a value to start with one of the prefixes, eg
+// prefix=com.HailoOSS.|com.example.
+func validatePrefix(value, arg string) error {
+	prefixes := strings.Split(arg, "|")
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("must start with %s", strings.Join(prefixes, " or "))
+}
+
+// validateHost requires a URL to be on one of the hosts or their subdomains,
+// eg host=artifacts.hailo.com|s3.amazonaws.com
+func validateHost(value, arg string) error {
+	hosts := strings.Split(arg, "|")
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("must be a URL")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return nil
+		}
+	}
+	return fmt.Errorf("must be on %s", strings.Join(hosts, " or "))
+}
+
+// checkGoVersion requires the Go version of Go builds
+func checkGoVersion(s interface{}) []*validate.FieldError {
+	b := s.(*models.Build)
+	if b.Language == "Go" && b.GoVersion == "" {
+		return []*validate.FieldError{{
+			Field:   "GoVersion",
+			Path:    "GoVersion",
+			Message: "GoVersion cannot be blank for Go builds",
+		}}
+	}
+	return nil
+}
+
+// checkStatus requires the fields which depend on the status of a build
+func checkStatus(s interface{}) []*validate.FieldError {
+	b := s.(*models.Build)
+
+	errors := make([]*validate.FieldError, 0)
+	if b.Succeeded() && b.BinaryURL == "" && len(b.Artifacts) == 0 {
+		errors = append(errors, &validate.FieldError{
+			Field:   "BinaryURL",
+			Path:    "BinaryURL",
+			Message: "BinaryURL cannot be blank for succeeded builds without Artifacts",
+		})
+	}
+	if b.FailureReason != "" && b.Status != models.StatusFailed && b.Status != models.StatusCancelled {
+		errors = append(errors, &validate.FieldError{
+			Field:   "FailureReason",
+			Path:    "FailureReason",
+			Message: "FailureReason must be blank unless the build failed or was cancelled",
+		})
+	}
+	if b.FinishedAt != 0 && b.FinishedAt < b.StartedAt {
+		errors = append(errors, &validate.FieldError{
+			Field:   "FinishedAt",
+			Path:    "FinishedAt",
+			Message: "FinishedAt must not be before StartedAt",
+		})
+	}
+	return errors
+}
+
+// checkChecksum requires the checksum and size of a binary with its URL
+func checkChecksum(s interface{}) []*validate.FieldError {
+	b := s.(*models.Build)
+	if b.BinaryURL == "" {
+		return nil
+	}
+
+	errors := make([]*validate.FieldError, 0)
+	if b.BinarySHA256 == "" {
+		errors = append(errors, &validate.FieldError{
+			Field:   "BinarySHA256",
+			Path:    "BinarySHA256",
+			Message: "BinarySHA256 cannot be blank with a BinaryURL",
+		})
+	}
+	if b.BinarySize <= 0 {
+		errors = append(errors, &validate.FieldError{
+			Field:   "BinarySize",
+			Path:    "BinarySize",
+			Message: "BinarySize must be positive with a BinaryURL",
+		})
+	}
+	return errors
+}
+
+// checkArtifacts requires a build to have one artifact of each kind for a
+// platform, and the size of every file
+func checkArtifacts(s interface{}) []*validate.FieldError {
+	b := s.(*models.Build)
+
+	errors := make([]*validate.FieldError, 0)
+	seen := map[string]bool{}
+	for i, a := range b.Artifacts {
+		path := fmt.Sprintf("Artifacts[%d]", i)
+		if seen[a.Key()] {
+			errors = append(errors, &validate.FieldError{
+				Field:   "Artifacts",
+				Path:    path,
+				Message: fmt.Sprintf("%s is a second %s artifact for %s/%s", path, a.Kind, a.OS, a.Architecture),
+			})
+		}
+		seen[a.Key()] = true
+
+		if a.Kind != models.ArtifactDocker && a.Size <= 0 {
+			errors = append(errors, &validate.FieldError{
+				Field:   "Size",
+				Path:    path + ".Size",
+				Message: path + ".Size must be positive for files",
+			})
+		}
+	}
+	return errors
+}
+
+// checkStatements requires statement counts to be consistent with each other
+// and with the coverage of each package
+func checkStatements(s interface{}) []*validate.FieldError {
+	b := s.(*models.Build)
+
+	packages := make([]string, 0, len(b.Statements))
+	for pkg := range b.Statements {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	errors := make([]*validate.FieldError, 0)
+	for _, pkg := range packages {
+		count := b.Statements[pkg]
+		field := fmt.Sprintf("Statements[%q]", pkg)
+		if _, ok := b.Coverage[pkg]; !ok {
+			errors = append(errors, &validate.FieldError{
+				Field:   field,
+				Path:    field,
+				Message: fmt.Sprintf("%s has no matching Coverage", field),
+			})
+		}
+		if count.Covered < 0 || count.Covered > count.Total {
+			errors = append(errors, &validate.FieldError{
+				Field:   field,
+				Path:    field,
+				Message: fmt.Sprintf("%s must have between 0 and Total statements covered", field),
+			})
+		}
+	}
+	return errors
+}
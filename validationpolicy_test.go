@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+	"github.com/HailoOSS/build-service/validate"
+)
+
+func TestLoadValidationPolicies(t *testing.T) {
+	testCases := []struct {
+		data          string
+		expectedCount int
+		expectError   bool
+	}{
+		{`[{"Prefix":"","Fields":{"Name":"prefix=com.HailoOSS."}},{"Prefix":"com.HailoOSS.kernel.","Checks":["goversion"]}]`, 2, false},
+		{`[{"Prefix":"","Fields":{"Name":"shiny"}}]`, 0, true},
+		{`[{"Prefix":"","Checks":["shiny"]}]`, 0, true},
+		{`not json`, 0, true},
+	}
+
+	for i, tc := range testCases {
+		f, err := ioutil.TempFile("", "policies")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.data)
+		f.Close()
+
+		policies, err := loadValidationPolicies(f.Name())
+		os.Remove(f.Name())
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, got %v (%d)", tc.expectError, err, i)
+			continue
+		}
+		if len(policies) != tc.expectedCount {
+			t.Errorf("Expected %v policies, got %v (%d)", tc.expectedCount, len(policies), i)
+		}
+	}
+}
+
+func TestValidationPolicies(t *testing.T) {
+	policies := ValidationPolicies{
+		{Prefix: "", Policy: validate.Policy{Fields: map[string]string{"Name": "prefix=com.HailoOSS."}}},
+		{Prefix: "com.HailoOSS.kernel.", Policy: validate.Policy{
+			Fields: map[string]string{"BinaryURL": "host=amazon.com"},
+			Checks: []string{"goversion", "statements", "status", "checksum"},
+		}},
+	}
+
+	testCases := []struct {
+		modify   func(*models.Build)
+		expected []string
+	}{
+		{func(b *models.Build) {}, nil},
+		{func(b *models.Build) { b.Name = "org.example.service" }, []string{"Name must start with com.HailoOSS."}},
+		{func(b *models.Build) { b.Name = "com.HailoOSS.service"; b.GoVersion = "" }, nil},
+		{func(b *models.Build) { b.GoVersion = "" }, []string{"GoVersion cannot be blank for Go builds"}},
+		{func(b *models.Build) { b.BinaryURL = "http://example.com/abcdefg" }, []string{"BinaryURL must be on amazon.com"}},
+		{func(b *models.Build) { b.Version = "123" }, []string{"Version must be a timestamp formatted as YYYYMMDDhhmmss"}},
+		{func(b *models.Build) { b.BinaryURL, b.BinarySHA256, b.BinarySize = "", "", 0 }, []string{"BinaryURL cannot be blank for succeeded builds without Artifacts"}},
+		{func(b *models.Build) { b.BinarySHA256, b.BinarySize = "", 0 }, []string{
+			"BinarySHA256 cannot be blank with a BinaryURL",
+			"BinarySize must be positive with a BinaryURL",
+		}},
+		{func(b *models.Build) { b.Name = "com.HailoOSS.service"; b.BinarySize = 0 }, []string{"BinarySize must be positive with a BinaryURL"}},
+		{func(b *models.Build) { b.BinarySHA256 = "9F86D0" }, []string{"BinarySHA256 must be a 64 character hex SHA-256"}},
+		{func(b *models.Build) {
+			b.Statements = map[string]models.StatementCount{"dao": {Covered: 301, Total: 300}, "other": {Covered: 1, Total: 1}}
+		}, []string{
+			`Statements["dao"] must have between 0 and Total statements covered`,
+			`Statements["other"] has no matching Coverage`,
+		}},
+	}
+
+	for i, tc := range testCases {
+		b := validBuild()
+		tc.modify(&b)
+
+		errors := policies.Validate(&b)
+		if len(errors) != len(tc.expected) {
+			t.Errorf("Expected %v, Got %v (%d)", tc.expected, errors, i)
+			continue
+		}
+		for j, err := range errors {
+			if err.Message != tc.expected[j] {
+				t.Errorf("Expected %q, Got %q (%d)", tc.expected[j], err.Message, i)
+			}
+		}
+	}
+}
+
+func TestRequireChecksums(t *testing.T) {
+	defer func() { requireChecksums = true }()
+
+	policies := ValidationPolicies{
+		{Prefix: "com.HailoOSS.kernel.", Policy: validate.Policy{Checks: []string{"checksum"}}},
+	}
+
+	testCases := []struct {
+		require       bool
+		name          string
+		expectedCount int
+	}{
+		{true, "com.HailoOSS.service", 2},
+		{true, "com.HailoOSS.kernel.service", 2},
+		{false, "com.HailoOSS.service", 0},
+		{false, "com.HailoOSS.kernel.service", 2},
+	}
+
+	for i, tc := range testCases {
+		requireChecksums = tc.require
+
+		b := validBuild()
+		b.Name, b.BinarySHA256, b.BinarySize = tc.name, "", 0
+		if errors := policies.Validate(&b); len(errors) != tc.expectedCount {
+			t.Errorf("Expected %d errors, Got %v (%d)", tc.expectedCount, errors, i)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	headerChecksum = "X-Checksum-SHA256"
+
+	verifiedBuildsPerService = 10 // The latest successful builds of each service verified
+
+	fetchConnectTimeout  = 30 * time.Second
+	defaultVerifyTimeout = 10 * time.Minute
+)
+
+var (
+	errFetchUnsupported = errors.New("Unsupported binary URL")
+
+	regSHA256 = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+)
+
+// ArtifactFetcher defines the interface required to fetch the binaries of
+// builds for verification
+type ArtifactFetcher interface {
+	// Fetch returns the binary of a build, errBlobNotFound if it's missing,
+	// or errFetchUnsupported if it can't be fetched
+	Fetch(b *models.Build) (io.ReadCloser, error)
+}
+
+// httpFetcher downloads binaries with http and https URLs
+type httpFetcher struct {
+	client *http.Client
+}
+
+// newHTTPFetcher returns an httpFetcher which gives up on hosts which don't
+// connect or respond quickly, and on any binary taking longer than timeout to
+// download, or never if it's 0
+func newHTTPFetcher(timeout time.Duration) *httpFetcher {
+	return &httpFetcher{client: &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			Dial:                  (&net.Dialer{Timeout: fetchConnectTimeout}).Dial,
+			TLSHandshakeTimeout:   fetchConnectTimeout,
+			ResponseHeaderTimeout: fetchConnectTimeout,
+		},
+		Timeout: timeout,
+	}}
+}
+
+func (f *httpFetcher) Fetch(b *models.Build) (io.ReadCloser, error) {
+	u, err := url.Parse(b.BinaryURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errFetchUnsupported
+	}
+
+	resp, err := f.client.Get(b.BinaryURL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		resp.Body.Close()
+		return nil, errBlobNotFound
+	case resp.StatusCode != http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("Unexpected status fetching %s: %s", b.BinaryURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// storeFetcher reads binaries hosted by the service from the artifact store,
+// and fetches the rest with next
+type storeFetcher struct {
+	store BlobStore
+	next  ArtifactFetcher
+}
+
+func (f *storeFetcher) Fetch(b *models.Build) (io.ReadCloser, error) {
+	blob, _, err := f.store.Open(artifactKey(b.Name, b.Version))
+	if err == errBlobNotFound {
+		return f.next.Fetch(b)
+	}
+	return blob, err
+}
+
+// verifyArtifact fetches the binary of a build and checks it against the
+// checksum and size of the build
+func verifyArtifact(b *models.Build, fetcher ArtifactFetcher, now time.Time) (*models.Verification, error) {
+	rc, err := fetcher.Fetch(b)
+	if err == errBlobNotFound {
+		return &models.Verification{Time: now.Unix(), Result: models.VerifyMissing, Detail: "The binary couldn't be found"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, rc)
+	if err != nil {
+		return nil, err
+	}
+
+	v := b.Verify(hex.EncodeToString(hash.Sum(nil)), size)
+	v.Time = now.Unix()
+	return v, nil
+}
+
+// verifyBuilds verifies the binaries of the latest successful builds of
+// every service, recording the results and logging any which don't match
+func verifyBuilds(fetcher ArtifactFetcher, now time.Time) error {
+	names, err := buildRepo.GetNames("")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		builds, err := buildRepo.GetAllWithName(name, "", models.StatusSucceeded, false, verifiedBuildsPerService)
+		if err != nil {
+			return err
+		}
+
+		for _, b := range builds {
+			if b.BinarySHA256 == "" {
+				continue
+			}
+
+			v, err := verifyArtifact(b, fetcher, now)
+			if err == errFetchUnsupported {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to verify the binary of %s/%s: %v", b.Name, b.Version, err)
+				continue
+			}
+			if v.Result != models.VerifyOK {
+				log.Printf("The binary of %s/%s is %s: %s", b.Name, b.Version, v.Result, v.Detail)
+			}
+
+			if err := buildRepo.SetVerification(b.Name, b.Version, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// runVerifier verifies binaries every interval
+func runVerifier(fetcher ArtifactFetcher, interval time.Duration) {
+	for now := range time.Tick(interval) {
+		if err := verifyBuilds(fetcher, now); err != nil {
+			log.Printf("Failed to verify binaries: %v", err)
+		}
+	}
+}
+
+// verifyArtifactHandler checks the checksum, and optionally size, of a
+// downloaded binary or artifact against its build
+func verifyArtifactHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET VERIFY", r.URL)
+
+	query := r.URL.Query()
+	serviceName := query.Get(":name")
+	buildVersion := query.Get(":version")
+
+	checksum := query.Get("sha256")
+	if !regSHA256.MatchString(checksum) {
+		logHTTPError(rw, "sha256 must be a 64 character hex SHA-256", http.StatusBadRequest)
+		return
+	}
+	size := int64(-1)
+	if s := query.Get("size"); s != "" {
+		var err error
+		if size, err = strconv.ParseInt(s, 10, 64); err != nil || size < 0 {
+			logHTTPError(rw, fmt.Sprintf("Invalid size %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+	platform, err := platformFromQuery(query)
+	if err != nil {
+		logHTTPError(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	build, err := buildRepo.GetVersion(serviceName, buildVersion)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting build: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if build == nil || build.Deleted != nil {
+		logHTTPError(rw, "Build not found", http.StatusNotFound)
+		return
+	}
+
+	expected := build.Binary()
+	if platform != nil {
+		artifacts := build.FindArtifacts(platform.OS, platform.Architecture, platform.Kind)
+		if len(artifacts) == 0 {
+			logHTTPError(rw, "Artifact not found", http.StatusNotFound)
+			return
+		}
+		expected = &artifacts[0]
+	}
+	if expected.SHA256 == "" {
+		logHTTPError(rw, "The build has no checksum", http.StatusConflict)
+		return
+	}
+
+	v := expected.Verify(checksum, size)
+	v.Time = time.Now().Unix()
+
+	rw.Header().Set("Content-Type", "application/json")
+	if v.Result != models.VerifyOK {
+		rw.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const testChecksum = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" // The SHA-256 of "test"
+
+// testFetcher returns binaries by build version
+type testFetcher map[string]string
+
+func (f testFetcher) Fetch(b *models.Build) (io.ReadCloser, error) {
+	data, ok := f[b.Version]
+	if !ok {
+		return nil, errBlobNotFound
+	}
+	return ioutil.NopCloser(strings.NewReader(data)), nil
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+	build := validBuild()
+	build.Artifacts = []models.Artifact{{OS: "linux", Architecture: "arm64", Kind: models.ArtifactBinary, URL: "http://s3.amazon.com/arm64", SHA256: strings.Repeat("1", 64), Size: 9}}
+	repo.builds = append(repo.builds, &build)
+
+	noChecksum := validBuild()
+	noChecksum.Version = "20130627091747"
+	noChecksum.BinarySHA256 = ""
+	repo.builds = append(repo.builds, &noChecksum)
+
+	path := "/builds/com.HailoOSS.kernel.build-service/20130627091746/verify"
+	testCases := []struct {
+		path           string
+		expectedStatus int
+		expectedResult string
+	}{
+		{path + "?sha256=" + testChecksum, http.StatusOK, models.VerifyOK},
+		{path + "?sha256=" + strings.ToUpper(testChecksum) + "&size=4", http.StatusOK, models.VerifyOK},
+		{path + "?sha256=" + strings.Repeat("0", 64), http.StatusConflict, models.VerifyMismatch},
+		{path + "?sha256=" + testChecksum + "&size=5", http.StatusConflict, models.VerifyMismatch},
+		{path + "?sha256=" + strings.Repeat("1", 64) + "&size=9&os=linux&arch=arm64&kind=binary", http.StatusOK, models.VerifyOK},
+		{path + "?sha256=" + testChecksum + "&os=linux&arch=arm64&kind=binary", http.StatusConflict, models.VerifyMismatch},
+		{path + "?sha256=" + testChecksum + "&os=darwin&arch=arm64&kind=binary", http.StatusNotFound, ""},
+		{path + "?sha256=" + testChecksum + "&os=linux", http.StatusBadRequest, ""},
+		{path + "?sha256=abc", http.StatusBadRequest, ""},
+		{path + "?sha256=" + testChecksum + "&size=-1", http.StatusBadRequest, ""},
+		{"/builds/com.HailoOSS.kernel.build-service/20130627091748/verify?sha256=" + testChecksum, http.StatusNotFound, ""},
+		{"/builds/com.HailoOSS.kernel.build-service/20130627091747/verify?sha256=" + testChecksum, http.StatusConflict, ""},
+	}
+
+	for i, tc := range testCases {
+		req, _ := http.NewRequest("GET", tc.path, nil)
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expectedStatus {
+			t.Errorf("Expected %v, Got %v: %s (%d)", tc.expectedStatus, recorder.Code, recorder.Body, i)
+			continue
+		}
+		if tc.expectedResult != "" {
+			v := new(models.Verification)
+			json.NewDecoder(recorder.Body).Decode(v)
+			if v.Result != tc.expectedResult {
+				t.Errorf("Expected %v, Got %v (%d)", tc.expectedResult, v.Result, i)
+			}
+		}
+	}
+}
+
+func TestVerifyBuilds(t *testing.T) {
+	repo := newTestRepo()
+	buildRepo = repo
+
+	versions := []string{"20130627091746", "20130627091747", "20130627091748", "20130627091749"}
+	for i, version := range versions {
+		b := validBuild()
+		b.Version = version
+		b.TimeStamp += int64(i)
+		repo.builds = append(repo.builds, &b)
+	}
+	repo.builds[3].BinarySHA256 = ""
+
+	fetcher := testFetcher{versions[0]: "test", versions[1]: "tampered", versions[3]: "test"}
+	now := time.Unix(1400000000, 0)
+	if err := verifyBuilds(fetcher, now); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{models.VerifyOK, models.VerifyMismatch, models.VerifyMissing, ""}
+	for i, b := range repo.builds {
+		result := ""
+		if b.Verification != nil {
+			result = b.Verification.Result
+			if b.Verification.Time != now.Unix() {
+				t.Errorf("Expected %v, Got %v (%d)", now.Unix(), b.Verification.Time, i)
+			}
+		}
+		if result != expected[i] {
+			t.Errorf("Expected %q, Got %q (%d)", expected[i], result, i)
+		}
+	}
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/binary":
+			rw.Write([]byte("test"))
+		case "/slow":
+			rw.Write([]byte("te"))
+			rw.(http.Flusher).Flush()
+			time.Sleep(200 * time.Millisecond)
+			rw.Write([]byte("st"))
+		default:
+			http.NotFound(rw, r)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		path        string
+		timeout     time.Duration
+		expected    string
+		expectError bool
+	}{
+		{"/binary", 0, "test", false},
+		{"/slow", 0, "test", false},
+		{"/slow", 50 * time.Millisecond, "", true},
+		{"/missing", 0, "", true},
+	}
+
+	for i, tc := range testCases {
+		fetcher := newHTTPFetcher(tc.timeout)
+		body, err := fetcher.Fetch(&models.Build{BinaryURL: server.URL + tc.path})
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(body)
+			body.Close()
+		}
+
+		if (err != nil) != tc.expectError {
+			t.Errorf("Expected error: %v, Got %v (%d)", tc.expectError, err, i)
+			continue
+		}
+		if !tc.expectError && string(data) != tc.expected {
+			t.Errorf("Expected %q, Got %q (%d)", tc.expected, data, i)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+const (
+	defaultVolatilityBuilds    = 50
+	defaultVolatilityThreshold = 1.0
+)
+
+type byStdDev []models.VolatilePackage
+
+func (v byStdDev) Len() int      { return len(v) }
+func (v byStdDev) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v byStdDev) Less(i, j int) bool {
+	if v[i].StdDev != v[j].StdDev {
+		return v[i].StdDev > v[j].StdDev
+	}
+	return v[i].PackageName < v[j].PackageName
+}
+
+// findVolatilePackages compares the coverage of builds of the same commit on
+// the same branch, and returns the packages whose coverage has a standard
+// deviation above the threshold, most volatile first
+func findVolatilePackages(builds []*models.Build, threshold float64) []models.VolatilePackage {
+	type commitKey struct {
+		branch    string
+		sourceURL string
+	}
+
+	keys := make([]commitKey, 0)
+	byCommit := make(map[commitKey][]*models.Build)
+	for _, b := range builds {
+		key := commitKey{b.Branch, b.SourceURL}
+		if _, ok := byCommit[key]; !ok {
+			keys = append(keys, key)
+		}
+		byCommit[key] = append(byCommit[key], b)
+	}
+
+	volatile := make([]models.VolatilePackage, 0)
+	for _, key := range keys {
+		commitBuilds := byCommit[key]
+		if len(commitBuilds) < 2 {
+			continue
+		}
+
+		versions := make([]string, len(commitBuilds))
+		for i, b := range commitBuilds {
+			versions[i] = b.Version
+		}
+		sort.Strings(versions)
+
+		byPackage := make(map[string][]float64)
+		for _, b := range commitBuilds {
+			for pkg, perc := range b.Coverage {
+				byPackage[pkg] = append(byPackage[pkg], perc)
+			}
+		}
+
+		for pkg, values := range byPackage {
+			if len(values) < 2 {
+				continue
+			}
+
+			vp := models.VolatilePackage{
+				PackageName: pkg,
+				Branch:      key.branch,
+				SourceURL:   key.sourceURL,
+				Versions:    versions,
+				Min:         values[0],
+				Max:         values[0],
+			}
+
+			var sum float64
+			for _, v := range values {
+				sum += v
+				vp.Min = math.Min(vp.Min, v)
+				vp.Max = math.Max(vp.Max, v)
+			}
+			mean := sum / float64(len(values))
+
+			var variance float64
+			for _, v := range values {
+				variance += (v - mean) * (v - mean)
+			}
+			variance /= float64(len(values))
+
+			vp.Mean = models.Round(mean)
+			vp.StdDev = models.Round(math.Sqrt(variance))
+			if vp.StdDev > threshold {
+				volatile = append(volatile, vp)
+			}
+		}
+	}
+
+	sort.Sort(byStdDev(volatile))
+
+	return volatile
+}
+
+func getCoverageVolatilityHandler(rw http.ResponseWriter, r *http.Request) {
+	log.Println("GET COVERAGE_VOLATILITY", r.URL)
+
+	serviceName := r.URL.Query().Get(":name")
+	branch := r.URL.Query().Get("branch")
+
+	limit := defaultVolatilityBuilds
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+		limit = l
+	}
+	threshold := defaultVolatilityThreshold
+	if t, err := strconv.ParseFloat(r.URL.Query().Get("threshold"), 64); err == nil {
+		threshold = t
+	}
+
+	builds, err := buildRepo.GetAllWithName(serviceName, branch, "", false, limit)
+	if err != nil {
+		logHTTPError(rw, fmt.Sprintf("Error getting builds: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(findVolatilePackages(builds, threshold))
+}
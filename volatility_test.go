@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/HailoOSS/build-service/models"
+)
+
+func volatilityBuilds() []*models.Build {
+	coverages := []struct {
+		version   string
+		branch    string
+		sourceURL string
+		coverage  map[string]float64
+	}{
+		{"0", "feature", "commit/c", map[string]float64{"dao": 40, "domain": 80}},
+		{"1", "master", "commit/a", map[string]float64{"dao": 50, "domain": 80}},
+		{"2", "master", "commit/a", map[string]float64{"dao": 60, "domain": 80.5}},
+		{"3", "master", "commit/a", map[string]float64{"dao": 70, "domain": 80}},
+		{"4", "master", "commit/b", map[string]float64{"dao": 10, "domain": 10}},
+		{"5", "feature", "commit/a", map[string]float64{"dao": 90, "domain": 80}},
+	}
+
+	builds := make([]*models.Build, len(coverages))
+	for i, c := range coverages {
+		b := validBuild()
+		b.Version = c.version
+		b.Branch = c.branch
+		b.SourceURL = c.sourceURL
+		b.Statements = nil
+		b.Coverage = c.coverage
+		builds[i] = &b
+	}
+	return builds
+}
+
+func TestFindVolatilePackages(t *testing.T) {
+	expected := []models.VolatilePackage{
+		{
+			PackageName: "dao",
+			Branch:      "master",
+			SourceURL:   "commit/a",
+			Versions:    []string{"1", "2", "3"},
+			Mean:        60,
+			StdDev:      8.16,
+			Min:         50,
+			Max:         70,
+		},
+	}
+
+	actual := findVolatilePackages(volatilityBuilds(), 1)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("\nExpected:%+v\nGot     :%+v", expected, actual)
+	}
+
+	// domain varies by 0.24, so is only flagged with a lower threshold
+	if actual := findVolatilePackages(volatilityBuilds(), 0.1); len(actual) != 2 || actual[1].PackageName != "domain" {
+		t.Errorf("Expected dao and domain to be volatile, got %+v", actual)
+	}
+}
+
+func TestGetCoverageVolatility(t *testing.T) {
+	testCases := []struct {
+		path          string
+		expectedCount int
+	}{
+		{"/builds?:name=com.HailoOSS.kernel.build-service", 1},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&branch=feature", 0},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&threshold=0.1", 2},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&limit=1", 0},
+		{"/builds?:name=com.HailoOSS.kernel.build-service&branch=master&limit=2", 1},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", tc.path, nil)
+
+		repo := newTestRepo()
+		repo.builds = volatilityBuilds()
+		buildRepo = repo
+
+		getCoverageVolatilityHandler(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected %v, Got %v (%d)", http.StatusOK, recorder.Code, i)
+			continue
+		}
+
+		var volatile []models.VolatilePackage
+		json.NewDecoder(recorder.Body).Decode(&volatile)
+		if len(volatile) != tc.expectedCount {
+			t.Errorf("Expected %d volatile packages, got %d (%d)", tc.expectedCount, len(volatile), i)
+		}
+	}
+}